@@ -31,16 +31,28 @@ type tomlConfig struct {
 	Logging       logging
 	Alert         alert
 	Elasticsearch elasticsearch
+	Sandbox       sandbox
+	Verdict       verdict
+	API           api
+	ICAP          icap
+	Unpack        unpack
+	Quarantine    quarantine
+	Cache         cache
+	Updates       updates
+	Staleness     staleness
 }
 
 type env struct {
-	Runtime     string `toml:"runtime"`
-	Filestore   string `toml:"filestore"`
-	CPUcores    int    `toml:"cpu_cores"`
-	MaxFileProc int    `toml:"max_file_proc"`
-	Client      string `toml:"client"`
-	Site        string `toml:"site"`
-	Network     string `toml:"network"`
+	Runtime       string `toml:"runtime"`
+	Filestore     string `toml:"filestore"`
+	CPUcores      int    `toml:"cpu_cores"`
+	MaxFileProc   int    `toml:"max_file_proc"`
+	MaxPluginProc int    `toml:"max_plugin_proc"`
+	QueueDepth    int    `toml:"queue_depth"`
+	DrainTimeout  string `toml:"drain_timeout"`
+	Client        string `toml:"client"`
+	Site          string `toml:"site"`
+	Network       string `toml:"network"`
 }
 
 type logging struct {
@@ -52,13 +64,51 @@ type logging struct {
 }
 
 type alert struct {
-	LocalPath         string `toml:"local_path"`
-	RemotePath        string `toml:"remote_path"`
-	DynamicRemoteHost bool   `toml:"dynamic_remote_host"`
-	RemoteHost        string `toml:"remote_host"`
-	RemotePort        string `toml:"remote_port"`
-	ScpPkey           string `toml:"scp_pkey"`
-	ScpUser           string `toml:"scp_user"`
+	LocalPath         string      `toml:"local_path"`
+	RemotePath        string      `toml:"remote_path"`
+	DynamicRemoteHost bool        `toml:"dynamic_remote_host"`
+	RemoteHost        string      `toml:"remote_host"`
+	RemotePort        string      `toml:"remote_port"`
+	ScpPkey           string      `toml:"scp_pkey"`
+	ScpUser           string      `toml:"scp_user"`
+	Sinks             []AlertSink `toml:"sinks"`
+}
+
+//AlertSink - A destination alerts are sent to, only the settings for the sinks type are used
+type AlertSink struct {
+	Type       string `toml:"type"`
+	Name       string `toml:"name"`
+	Retries    int    `toml:"retries"`
+	RetryDelay string `toml:"retry_delay"`
+	Timeout    string `toml:"timeout"`
+
+	//webhook
+	URL        string            `toml:"url"`
+	Headers    map[string]string `toml:"headers"`
+	HMACSecret string            `toml:"hmac_secret"`
+	HMACHeader string            `toml:"hmac_header"`
+
+	//syslog and smtp
+	Network            string `toml:"network"`
+	Address            string `toml:"address"`
+	Facility           string `toml:"facility"`
+	AppName            string `toml:"app_name"`
+	CA                 string `toml:"ca"`
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify"`
+
+	//smtp
+	Username string   `toml:"username"`
+	Password string   `toml:"password"`
+	From     string   `toml:"from"`
+	To       []string `toml:"to"`
+
+	//scp
+	LocalPath  string `toml:"local_path"`
+	RemotePath string `toml:"remote_path"`
+	RemoteHost string `toml:"remote_host"`
+	RemotePort string `toml:"remote_port"`
+	ScpPkey    string `toml:"scp_pkey"`
+	ScpUser    string `toml:"scp_user"`
 }
 
 type elasticsearch struct {
@@ -72,6 +122,74 @@ type elasticsearch struct {
 	Ca       string `toml:"es_ca"`
 }
 
+type sandbox struct {
+	Scratch        string `toml:"scratch"`
+	SeccompProfile string `toml:"seccomp_profile"`
+	TmpfsSize      string `toml:"tmpfs_size"`
+	Instance       string `toml:"instance"`
+	ReapInterval   string `toml:"reap_interval"`
+}
+
+type verdict struct {
+	MinDetections int                `toml:"min_detections"`
+	Score         float64            `toml:"score"`
+	Weights       map[string]float64 `toml:"weights"`
+	Sufficient    []string           `toml:"sufficient"`
+	Alert         []string           `toml:"alert"`
+}
+
+type api struct {
+	Listen        string `toml:"listen"`
+	MaxUploadSize string `toml:"max_upload_size"`
+	MaxResults    int    `toml:"max_results"`
+}
+
+type unpack struct {
+	Enabled      bool     `toml:"enabled"`
+	MaxDepth     int      `toml:"max_depth"`
+	MaxTotalSize string   `toml:"max_total_size"`
+	MaxEntries   int      `toml:"max_entries"`
+	MaxRatio     float64  `toml:"max_ratio"`
+	Passwords    []string `toml:"passwords"`
+}
+
+type quarantine struct {
+	Enabled   bool     `toml:"enabled"`
+	Dir       string   `toml:"dir"`
+	Verdicts  []string `toml:"verdicts"`
+	Wrapping  string   `toml:"wrapping"`
+	Key       string   `toml:"key"`
+	Retention string   `toml:"retention"`
+	MaxSize   string   `toml:"max_size"`
+}
+
+type cache struct {
+	Enabled bool   `toml:"enabled"`
+	Dir     string `toml:"dir"`
+	TTL     string `toml:"ttl"`
+}
+
+type updates struct {
+	Enabled  bool   `toml:"enabled"`
+	Schedule string `toml:"schedule"`
+	SelfTest bool   `toml:"self_test"`
+}
+
+type staleness struct {
+	Enabled         bool   `toml:"enabled"`
+	MaxUpdateAge    string `toml:"max_update_age"`
+	MaxSignatureAge string `toml:"max_signature_age"`
+	CheckInterval   string `toml:"check_interval"`
+}
+
+type icap struct {
+	Listen      string   `toml:"listen"`
+	Service     string   `toml:"service"`
+	MaxBodySize string   `toml:"max_body_size"`
+	Block       []string `toml:"block"`
+	BlockPage   string   `toml:"block_page"`
+}
+
 func Load() {
 
 	configPath := filepath.Join(utils.GetConfigDir(), configFile)
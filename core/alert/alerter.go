@@ -0,0 +1,241 @@
+package alert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"malscan/config"
+	"malscan/core/utils"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains the alerter interface and sends alerts to every configured sink in parallel
+
+*/
+
+//Kinds of alert
+const (
+	KindMalware = "malware"
+)
+
+const (
+	defaultRetries    = 3
+	defaultRetryDelay = 5 * time.Second
+	defaultTimeout    = 10 * time.Second
+)
+
+//Alert - An alert sent to every sink, malware alerts set Filename and Result and operational alerts set Subject and Message
+type Alert struct {
+	Kind     string `json:"Kind"`
+	Filename string `json:"Filename,omitempty"`
+	Result   string `json:"Result,omitempty"`
+	Subject  string `json:"Subject,omitempty"`
+	Message  string `json:"Message,omitempty"`
+	Time     string `json:"Time"`
+}
+
+//Alerter - Sends alerts to one destination
+type Alerter interface {
+	//Name - Names the sink in logs
+	Name() string
+	//Send - Sends the alert once, failed sends are retried by the caller
+	Send(alert Alert) error
+}
+
+//dropper - Implemented by alerters that keep state about an alert between attempts, Drop is called once
+//the alert has run out of retries so the state can be cleared
+type dropper interface {
+	Drop(alert Alert)
+}
+
+//sink - An alerter with its retry settings
+type sink struct {
+	alerter    Alerter
+	retries    int
+	retryDelay time.Duration
+}
+
+var (
+	sinks     []sink
+	sinksOnce sync.Once
+)
+
+//loadSinks - Creates a sink for each sink in the malscan config, with none configured the scp settings in the alert section
+//are used as a single scp sink. Sinks that are not configured correctly are logged and left out
+func loadSinks() []sink {
+
+	sinksOnce.Do(func() {
+
+		configured := config.Values.Alert.Sinks
+		if len(configured) == 0 {
+			configured = []config.AlertSink{{
+				Type:       sinkSCP,
+				LocalPath:  config.Values.Alert.LocalPath,
+				RemotePath: config.Values.Alert.RemotePath,
+				RemoteHost: config.Values.Alert.RemoteHost,
+				RemotePort: config.Values.Alert.RemotePort,
+				ScpPkey:    config.Values.Alert.ScpPkey,
+				ScpUser:    config.Values.Alert.ScpUser,
+			}}
+		}
+
+		for i, settings := range configured {
+			if settings.Name == "" {
+				settings.Name = settings.Type
+			}
+
+			s, err := newSink(settings)
+			if err != nil {
+				log.WithFields(log.Fields{"err": err}).Errorf("not using alert sink:%d:%s", i, settings.Name)
+				continue
+			}
+
+			sinks = append(sinks, s)
+		}
+	})
+
+	return sinks
+}
+
+//newSink - Creates the alerter for the sinks type along with its retry settings
+func newSink(settings config.AlertSink) (s sink, err error) {
+
+	timeout := defaultTimeout
+	if settings.Timeout != "" {
+		if timeout, err = utils.ParseAge(settings.Timeout); err != nil {
+			return s, errors.Wrap(err, "invalid timeout")
+		}
+	}
+
+	s.retries = defaultRetries
+	if settings.Retries != 0 {
+		s.retries = settings.Retries
+	}
+	if s.retries < 0 {
+		s.retries = 0 //A negative amount turns retrying off
+	}
+
+	s.retryDelay = defaultRetryDelay
+	if settings.RetryDelay != "" {
+		if s.retryDelay, err = utils.ParseAge(settings.RetryDelay); err != nil {
+			return s, errors.Wrap(err, "invalid retry_delay")
+		}
+	}
+
+	switch settings.Type {
+	case sinkWebhook:
+		s.alerter, err = newWebhook(settings, timeout)
+	case sinkSyslog:
+		s.alerter, err = newSyslog(settings, timeout)
+	case sinkSMTP:
+		s.alerter, err = newSMTP(settings, timeout)
+	case sinkSCP:
+		s.alerter, err = newSCP(settings, timeout)
+	default:
+		err = errors.New("unknown alert sink type: " + settings.Type)
+	}
+
+	return s, err
+}
+
+//newTLSConfig - Returns the tls config for sinks that connect with tls, the sinks ca is trusted on top of the system roots
+func newTLSConfig(settings config.AlertSink) (*tls.Config, error) {
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: settings.InsecureSkipVerify}
+
+	if settings.CA != "" {
+		pem, err := ioutil.ReadFile(settings.CA)
+		if err != nil {
+			return nil, errors.Wrap(err, "error while reading ca")
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in ca: " + settings.CA)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+//send - Responsible for sending an alert to every sink in parallel, blocks until every sink has sent it or run out of retries
+func send(alert Alert) {
+
+	if alert.Time == "" {
+		alert.Time = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	var wg sync.WaitGroup
+
+	for _, s := range loadSinks() {
+		wg.Add(1)
+		go func(s sink) {
+			defer wg.Done()
+			s.send(alert)
+		}(s)
+	}
+
+	wg.Wait()
+}
+
+//send - Sends the alert with the sinks alerter, retrying with a doubling delay until it succeeds or runs out of retries
+func (s sink) send(alert Alert) {
+
+	delay := s.retryDelay
+
+	for attempt := 0; ; attempt++ {
+
+		err := s.alerter.Send(alert)
+		if err == nil {
+			log.Infof("sent %s alert:%s:with sink:%s", alert.Kind, alert.about(), s.alerter.Name())
+			return
+		}
+
+		if attempt >= s.retries {
+			log.WithFields(log.Fields{"err": err}).Errorf("failed to send %s alert:%s:with sink:%s:after %d attempts", alert.Kind, alert.about(), s.alerter.Name(), attempt+1)
+			if d, ok := s.alerter.(dropper); ok {
+				d.Drop(alert)
+			}
+			return
+		}
+
+		log.WithFields(log.Fields{"err": err}).Warnf("sending %s alert:%s:with sink:%s:failed, retrying in:%s", alert.Kind, alert.about(), s.alerter.Name(), delay)
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+//about - Returns what the alert is about, the file for malware alerts and the subject for operational alerts
+func (alert Alert) about() string {
+
+	if alert.Filename != "" {
+		return alert.Filename
+	}
+
+	return alert.Subject
+}
+
+//summary - Returns a one line summary of the alert, used where a sink needs plain text such as an email subject
+func (alert Alert) summary() string {
+
+	if alert.Kind == KindMalware {
+		return "malware detected: " + alert.Result + " in " + alert.Filename
+	}
+
+	return alert.Kind + ": " + alert.Subject + ": " + alert.Message
+}
@@ -0,0 +1,27 @@
+package alert
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Generate operational alerts for malscan, these are about the health of malscan itself rather than a file
+
+*/
+
+//Operational - Responsible for generating an operational alert, kind says what went wrong and subject what it went wrong
+//with (for example a plugin). The alert is sent to the same sinks as malware alerts, in the background so the caller
+//is not held by slow sinks and their retries
+func Operational(kind string, subject string, message string) {
+
+	log.Warnf("operational alert:%s:%s:%s", kind, subject, message)
+
+	go send(Alert{
+		Kind:    kind,
+		Subject: subject,
+		Message: message,
+	})
+}
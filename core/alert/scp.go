@@ -0,0 +1,136 @@
+package alert
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"malscan/config"
+	"malscan/core/scp"
+	"malscan/core/utils"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains the scp alert sink, alerts are appended to a local file which is then copied to a remote host
+
+*/
+
+const sinkSCP = "scp"
+
+//scpAlerter - Appends alerts as json lines to a local file and copies the whole file to the remote host with scp
+type scpAlerter struct {
+	name       string
+	localPath  string
+	remotePath string
+	remoteHost string
+	remotePort string
+	user       string
+	pkey       string
+	timeout    time.Duration
+
+	mutex   sync.Mutex
+	pending map[Alert]bool //Alerts appended to the local file that have not been copied yet, so a retry does not append them again
+}
+
+//newSCP - Creates an scp sink, alerts are appended to alert.log in the logs dir when no local path is set
+func newSCP(settings config.AlertSink, timeout time.Duration) (*scpAlerter, error) {
+
+	localPath := settings.LocalPath
+	if localPath == "" {
+		localPath = filepath.Join(utils.GetLogsDir(), "alert.log")
+	}
+
+	return &scpAlerter{
+		name:       settings.Name,
+		localPath:  localPath,
+		remotePath: settings.RemotePath,
+		remoteHost: settings.RemoteHost,
+		remotePort: settings.RemotePort,
+		user:       settings.ScpUser,
+		pkey:       settings.ScpPkey,
+		timeout:    timeout,
+		pending:    make(map[Alert]bool),
+	}, nil
+}
+
+//Name - Returns the name of the sink
+func (a *scpAlerter) Name() string {
+
+	return a.name
+}
+
+//Send - Appends the alert to the local file and copies the file to the remote host, with no remote host the alert is only appended.
+//When dynamic_remote_host is set malware alerts are copied to the instance the file came from, if its name has one
+func (a *scpAlerter) Send(alert Alert) error {
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if !a.pending[alert] {
+		if err := a.append(alert); err != nil {
+			return err
+		}
+		a.pending[alert] = true
+	}
+
+	if a.remoteHost == "" {
+		delete(a.pending, alert)
+		log.Debugf("no remote host set for alert sink:%s:alert only written to:%s", a.name, a.localPath)
+		return nil
+	}
+
+	fullHostname := a.remoteHost + ":" + a.remotePort
+	if config.Values.Alert.DynamicRemoteHost == true {
+		if instance := utils.ParseInstance(alert.Filename); instance != "" {
+			fullHostname = instance + "." + fullHostname
+		}
+	}
+
+	log.Debugf("sending alert to remote location:%s:%s", fullHostname, a.remotePath)
+
+	if err := scp.Send(a.user, a.pkey, a.localPath, fullHostname, a.remotePath, a.timeout); err != nil {
+		return err
+	}
+
+	delete(a.pending, alert)
+
+	return nil
+}
+
+//Drop - Forgets an alert that ran out of retries, it stays in the local file and is copied along with the next alert
+func (a *scpAlerter) Drop(alert Alert) {
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	delete(a.pending, alert)
+}
+
+//append - Appends the alert to the local file as a json line
+func (a *scpAlerter) append(alert Alert) error {
+
+	hdjson, err := json.Marshal(alert)
+	if err != nil {
+		return errors.Wrap(err, "error while marshaling alert")
+	}
+
+	f, err := os.OpenFile(a.localPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrap(err, "error while opening alert file")
+	}
+
+	if _, err := f.Write(append(hdjson, '\n')); err != nil {
+		f.Close()
+		return errors.Wrap(err, "error while writing alert to alert file")
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,162 @@
+package alert
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"malscan/config"
+
+	"github.com/pkg/errors"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains the smtp alert sink, alerts are emailed
+
+*/
+
+const sinkSMTP = "smtp"
+
+//smtpAlerter - Emails alerts, STARTTLS is used when the server offers it unless network is tls which connects with tls straight away
+type smtpAlerter struct {
+	name      string
+	address   string
+	host      string
+	username  string
+	password  string
+	from      string
+	to        []string
+	implicit  bool
+	tlsConfig *tls.Config
+	timeout   time.Duration
+}
+
+//newSMTP - Creates an smtp sink
+func newSMTP(settings config.AlertSink, timeout time.Duration) (*smtpAlerter, error) {
+
+	if settings.Address == "" {
+		return nil, errors.New("no address set for smtp sink")
+	}
+	if settings.From == "" || len(settings.To) == 0 {
+		return nil, errors.New("from and to must be set for smtp sink")
+	}
+
+	host, _, err := net.SplitHostPort(settings.Address)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid smtp address")
+	}
+
+	tlsConfig, err := newTLSConfig(settings)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.ServerName = host //STARTTLS does not work the server name out from the address
+
+	return &smtpAlerter{
+		name:      settings.Name,
+		address:   settings.Address,
+		host:      host,
+		username:  settings.Username,
+		password:  settings.Password,
+		from:      settings.From,
+		to:        settings.To,
+		implicit:  settings.Network == "tls",
+		tlsConfig: tlsConfig,
+		timeout:   timeout,
+	}, nil
+}
+
+//Name - Returns the name of the sink
+func (a *smtpAlerter) Name() string {
+
+	return a.name
+}
+
+//Send - Emails the alert to every recipient, the whole exchange with the server is bounded by the sinks timeout
+func (a *smtpAlerter) Send(alert Alert) error {
+
+	dialer := &net.Dialer{Timeout: a.timeout}
+
+	var conn net.Conn
+	var err error
+	if a.implicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", a.address, a.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", a.address)
+	}
+	if err != nil {
+		return errors.Wrap(err, "error while connecting to smtp server: "+a.address)
+	}
+
+	conn.SetDeadline(time.Now().Add(a.timeout))
+
+	client, err := smtp.NewClient(conn, a.host)
+	if err != nil {
+		conn.Close()
+		return errors.Wrap(err, "error while greeting smtp server: "+a.address)
+	}
+	defer client.Close()
+
+	if !a.implicit {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(a.tlsConfig); err != nil {
+				return errors.Wrap(err, "error while starting tls with smtp server")
+			}
+		}
+	}
+
+	if a.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", a.username, a.password, a.host)); err != nil {
+			return errors.Wrap(err, "error while authenticating with smtp server")
+		}
+	}
+
+	if err := client.Mail(a.from); err != nil {
+		return errors.Wrap(err, "error while setting smtp sender")
+	}
+	for _, to := range a.to {
+		if err := client.Rcpt(to); err != nil {
+			return errors.Wrap(err, "error while adding smtp recipient: "+to)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return errors.Wrap(err, "error while starting smtp message")
+	}
+	if _, err := w.Write(a.message(alert)); err != nil {
+		return errors.Wrap(err, "error while writing smtp message")
+	}
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "error while sending smtp message")
+	}
+
+	return client.Quit()
+}
+
+//message - Returns the email for the alert, the summary is the subject and the alert json is the body
+func (a *smtpAlerter) message(alert Alert) []byte {
+
+	subject := strings.NewReplacer("\r", " ", "\n", " ").Replace(alert.summary())
+
+	body, _ := json.MarshalIndent(alert, "", "  ")
+
+	msg := new(bytes.Buffer)
+	fmt.Fprintf(msg, "From: %s\r\n", a.from)
+	fmt.Fprintf(msg, "To: %s\r\n", strings.Join(a.to, ", "))
+	fmt.Fprintf(msg, "Subject: [malscan] %s\r\n", subject)
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(msg, "\r\n%s\r\n\r\n%s\r\n", subject, strings.Replace(string(body), "\n", "\r\n", -1))
+
+	return msg.Bytes()
+}
@@ -0,0 +1,204 @@
+package alert
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"malscan/config"
+
+	"github.com/pkg/errors"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains the syslog alert sink, alerts are sent as RFC 5424 messages over udp, tcp or tls
+
+*/
+
+const (
+	sinkSyslog = "syslog"
+
+	defaultFacility = "local0"
+	defaultAppName  = "malscan"
+
+	//sdID - Structured data id alert fields are sent under, 32473 is the enterprise number reserved for examples
+	sdID = "malscan@32473"
+)
+
+//Severities alerts are sent with
+const (
+	severityCritical = 2
+	severityWarning  = 4
+)
+
+//facilities - Syslog facilities by name
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+//syslogAlerter - Sends each alert as one RFC 5424 message, tcp and tls messages are framed with their length (RFC 6587 and RFC 5425)
+type syslogAlerter struct {
+	name      string
+	network   string
+	address   string
+	facility  int
+	appName   string
+	hostname  string
+	tlsConfig *tls.Config
+	timeout   time.Duration
+}
+
+//newSyslog - Creates a syslog sink, network is one of udp, tcp and tls
+func newSyslog(settings config.AlertSink, timeout time.Duration) (*syslogAlerter, error) {
+
+	if settings.Address == "" {
+		return nil, errors.New("no address set for syslog sink")
+	}
+
+	network := settings.Network
+	if network == "" {
+		network = "udp"
+	}
+	if network != "udp" && network != "tcp" && network != "tls" {
+		return nil, errors.New("unknown syslog network: " + network + ", choose from udp, tcp and tls")
+	}
+
+	facilityName := settings.Facility
+	if facilityName == "" {
+		facilityName = defaultFacility
+	}
+	facility, ok := facilities[facilityName]
+	if !ok {
+		return nil, errors.New("unknown syslog facility: " + facilityName)
+	}
+
+	appName := settings.AppName
+	if appName == "" {
+		appName = defaultAppName
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = ""
+	}
+
+	a := &syslogAlerter{
+		name:     settings.Name,
+		network:  network,
+		address:  settings.Address,
+		facility: facility,
+		appName:  header(appName, 48),
+		hostname: header(hostname, 255),
+		timeout:  timeout,
+	}
+
+	if network == "tls" {
+		if a.tlsConfig, err = newTLSConfig(settings); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+//Name - Returns the name of the sink
+func (a *syslogAlerter) Name() string {
+
+	return a.name
+}
+
+//Send - Connects to the syslog server, sends the alert and disconnects
+func (a *syslogAlerter) Send(alert Alert) error {
+
+	dialer := &net.Dialer{Timeout: a.timeout}
+
+	var conn net.Conn
+	var err error
+	if a.network == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", a.address, a.tlsConfig)
+	} else {
+		conn, err = dialer.Dial(a.network, a.address)
+	}
+	if err != nil {
+		return errors.Wrap(err, "error while connecting to syslog server: "+a.address)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(a.timeout))
+
+	msg := a.format(alert)
+	if a.network != "udp" {
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		return errors.Wrap(err, "error while writing to syslog server: "+a.address)
+	}
+
+	return nil
+}
+
+//format - Formats the alert as an RFC 5424 message, the alert fields are sent as structured data and the summary as the message
+func (a *syslogAlerter) format(alert Alert) string {
+
+	severity := severityWarning
+	if alert.Kind == KindMalware {
+		severity = severityCritical
+	}
+
+	params := []string{param("kind", alert.Kind)}
+	if alert.Filename != "" {
+		params = append(params, param("file", alert.Filename), param("result", alert.Result))
+	}
+	if alert.Subject != "" {
+		params = append(params, param("subject", alert.Subject))
+	}
+
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s [%s %s] %s",
+		a.facility*8+severity,
+		alert.Time,
+		a.hostname,
+		a.appName,
+		os.Getpid(),
+		header(alert.Kind, 32),
+		sdID,
+		strings.Join(params, " "),
+		alert.summary(),
+	)
+}
+
+//param - Formats a structured data parameter, \ " and ] are escaped in the value
+func param(name string, value string) string {
+
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+
+	return name + `="` + value + `"`
+}
+
+//header - Returns a header field of at most max printable ascii characters, an empty field is sent as -
+func header(value string, max int) string {
+
+	value = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, value)
+
+	if value == "" {
+		return "-"
+	}
+	if len(value) > max {
+		value = value[:max]
+	}
+
+	return value
+}
@@ -0,0 +1,109 @@
+package alert
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"malscan/config"
+
+	"github.com/pkg/errors"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains the webhook alert sink, alerts are posted as json to a url
+
+*/
+
+const (
+	sinkWebhook = "webhook"
+
+	defaultHMACHeader = "X-Malscan-Signature"
+)
+
+//webhookAlerter - Posts alerts as json, when a secret is set the body is signed with HMAC-SHA256 so the receiver can verify it
+type webhookAlerter struct {
+	name    string
+	url     string
+	headers map[string]string
+	secret  []byte
+	header  string
+	client  *http.Client
+}
+
+//newWebhook - Creates a webhook sink
+func newWebhook(settings config.AlertSink, timeout time.Duration) (*webhookAlerter, error) {
+
+	if settings.URL == "" {
+		return nil, errors.New("no url set for webhook sink")
+	}
+
+	header := settings.HMACHeader
+	if header == "" {
+		header = defaultHMACHeader
+	}
+
+	return &webhookAlerter{
+		name:    settings.Name,
+		url:     settings.URL,
+		headers: settings.Headers,
+		secret:  []byte(settings.HMACSecret),
+		header:  header,
+		client:  &http.Client{Timeout: timeout},
+	}, nil
+}
+
+//Name - Returns the name of the sink
+func (a *webhookAlerter) Name() string {
+
+	return a.name
+}
+
+//Send - Posts the alert, any status other than 2xx is a failure. The signature header holds sha256= followed by the hex HMAC of the body
+func (a *webhookAlerter) Send(alert Alert) error {
+
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return errors.Wrap(err, "error while marshaling alert")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "error while creating webhook request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range a.headers {
+		req.Header.Set(name, value)
+	}
+
+	if len(a.secret) > 0 {
+		mac := hmac.New(sha256.New, a.secret)
+		mac.Write(body)
+		req.Header.Set(a.header, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "error while posting alert")
+	}
+	defer resp.Body.Close()
+
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("webhook returned status: " + strconv.Itoa(resp.StatusCode))
+	}
+
+	return nil
+}
@@ -0,0 +1,331 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"malscan/config"
+	"malscan/core/quarantine"
+	"malscan/core/scan"
+	"malscan/core/utils"
+	pconfig "malscan/plugins"
+	"malscan/structs"
+
+	units "github.com/docker/go-units"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains the http api used to submit samples and retrieve reports
+
+*/
+
+const (
+	defaultListen        = "127.0.0.1:8080"
+	defaultMaxUploadSize = 100 * 1024 * 1024
+	defaultFilename      = "sample"
+)
+
+//Server - Http api in front of a scan pool
+type Server struct {
+	pool          *scan.Pool
+	store         *store
+	maxUploadSize int64
+}
+
+//Serve - Responsible for starting the http api, samples submitted are scanned on a pool using the mode-3 settings from the malscan config.
+//On SIGINT or SIGTERM the api stops accepting samples and running scans are drained
+func Serve() error {
+
+	plugins := pconfig.PluginConfig{}
+	plugins = plugins.Load()
+	plugins.CollectVersions()
+
+	defer plugins.StartDaemonLease()()
+	defer plugins.StartStalenessMonitor()()
+	defer plugins.StartContainerReaper()()
+	defer plugins.StartUpdater()()
+
+	pool := scan.NewPool(scan.Settings{
+		Files:      config.Values.Env.MaxFileProc,
+		Plugins:    config.Values.Env.MaxPluginProc,
+		QueueDepth: config.Values.Env.QueueDepth,
+	}, plugins)
+
+	server, err := NewServer(pool)
+	if err != nil {
+		return err
+	}
+
+	listen := config.Values.API.Listen
+	if listen == "" {
+		listen = defaultListen
+	}
+
+	log.Infof("malscan api listening on:%s", listen)
+
+	httpServer := &http.Server{Addr: listen, Handler: server}
+
+	shutdown := make(chan struct{}) //Closed once the pool has been drained
+	go func() {
+		sig := <-scan.Signals()
+		log.Infof("received:%s:stopping malscan api", sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), scan.DrainTimeout())
+		defer cancel()
+		if err := httpServer.Shutdown(ctx); err != nil {
+			log.WithFields(log.Fields{"err": err}).Error("stopping http server")
+		}
+
+		//Samples are only kept until they are scanned so the samples of interrupted scans are removed rather than requeued
+		for _, filename := range pool.Shutdown(scan.DrainTimeout()) {
+			log.Warnf("removing unscanned sample:%s", filename)
+			os.RemoveAll(filepath.Dir(filename))
+		}
+		close(shutdown)
+	}()
+
+	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+
+	<-shutdown
+
+	return nil
+}
+
+//NewServer - Responsible for creating the api handler for the pool passed in
+func NewServer(pool *scan.Pool) (*Server, error) {
+
+	server := &Server{
+		pool:          pool,
+		store:         newStore(config.Values.API.MaxResults),
+		maxUploadSize: defaultMaxUploadSize,
+	}
+
+	if config.Values.API.MaxUploadSize != "" {
+		size, err := units.RAMInBytes(config.Values.API.MaxUploadSize)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid max_upload_size")
+		}
+		server.maxUploadSize = size
+	}
+
+	return server, nil
+}
+
+//ServeHTTP - Routes requests to the api handlers
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	switch {
+	case r.URL.Path == "/scans":
+		server.submit(w, r)
+	case strings.HasPrefix(r.URL.Path, "/scans/"):
+		server.getScan(w, r, strings.TrimPrefix(r.URL.Path, "/scans/"))
+	case strings.HasPrefix(r.URL.Path, "/files/"):
+		server.getFile(w, r, strings.TrimPrefix(r.URL.Path, "/files/"))
+	case r.URL.Path == "/stats":
+		server.stats(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+//submit - POST /scans, accepts a multipart upload in the "file" field or the sample as the raw body (named with ?name=)
+func (server *Server) submit(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST to submit a sample")
+		return
+	}
+
+	body, filename, err := server.sample(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	id, err := newID()
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("generating scan id")
+		writeError(w, http.StatusInternalServerError, "could not generate a scan id")
+		return
+	}
+
+	//Each sample gets its own directory so the original filename can be kept in the report
+	dir := filepath.Join(utils.GetUploadsDir(), id)
+	path := filepath.Join(dir, filename)
+
+	size, err := save(body, dir, path, server.maxUploadSize)
+	if err != nil {
+		os.RemoveAll(dir)
+		log.WithFields(log.Fields{"err": err}).Error("saving uploaded sample")
+		writeError(w, http.StatusInternalServerError, "could not save the sample")
+		return
+	}
+	if size > server.maxUploadSize {
+		os.RemoveAll(dir)
+		writeError(w, http.StatusRequestEntityTooLarge, "sample is larger than max_upload_size")
+		return
+	}
+
+	server.store.add(id, filename)
+
+	queued := server.pool.TrySubmit(path, func(filename string, report structs.FullFileReport) {
+		server.store.finish(id, report)
+		if quarantine.ShouldQuarantine(report) {
+			if err := quarantine.Put(filename, report); err != nil {
+				log.WithFields(log.Fields{"err": err}).Errorf("quarantining:%s", filename)
+			}
+		}
+		os.RemoveAll(dir)
+	})
+	if !queued {
+		server.store.remove(id)
+		os.RemoveAll(dir)
+		writeError(w, http.StatusServiceUnavailable, "the scan queue is full, try again later")
+		return
+	}
+
+	log.Infof("api queued:%s:as scan:%s", filename, id)
+
+	w.Header().Set("Location", "/scans/"+id)
+	writeJSON(w, http.StatusAccepted, map[string]string{"id": id, "status": statusQueued})
+}
+
+//getScan - GET /scans/{id}
+func (server *Server) getScan(w http.ResponseWriter, r *http.Request, id string) {
+
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "use GET to retrieve a scan")
+		return
+	}
+
+	found, ok := server.store.get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "no scan with id: "+id)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, found)
+}
+
+//getFile - GET /files/{sha256}, returns the most recent report for the file. Files that were not submitted to this api,
+//or were dropped from its results, are looked up in the report cache
+func (server *Server) getFile(w http.ResponseWriter, r *http.Request, sha256 string) {
+
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "use GET to retrieve a report")
+		return
+	}
+
+	report, ok := server.store.getBySha256(strings.ToLower(sha256))
+	if !ok {
+		report, ok = server.pool.Cached(strings.ToLower(sha256))
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "no report for sha256: "+sha256)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+//stats - GET /stats, returns the queue length and the amount of files being scanned
+func (server *Server) stats(w http.ResponseWriter, r *http.Request) {
+
+	writeJSON(w, http.StatusOK, server.pool.Stats())
+}
+
+//sample - Returns a reader for the uploaded sample and its filename
+func (server *Server) sample(r *http.Request) (io.Reader, string, error) {
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if mediaType != "multipart/form-data" {
+		return r.Body, cleanFilename(r.URL.Query().Get("name")), nil
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", errors.Wrap(err, "invalid multipart upload")
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, "", errors.New("multipart upload has no file field")
+		}
+		if err != nil {
+			return nil, "", errors.Wrap(err, "invalid multipart upload")
+		}
+		if part.FormName() == "file" {
+			return part, cleanFilename(part.FileName()), nil
+		}
+	}
+}
+
+//save - Writes at most max+1 bytes of the sample to path, returning how many bytes were read so oversized samples can be rejected
+func save(body io.Reader, dir string, path string, max int64) (int64, error) {
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return io.Copy(f, io.LimitReader(body, max+1))
+}
+
+//cleanFilename - Strips any directories from a client supplied filename
+func cleanFilename(name string) string {
+
+	name = filepath.Base(filepath.Clean("/" + strings.Replace(name, "\\", "/", -1)))
+	if name == "/" || name == "." {
+		return defaultFilename
+	}
+
+	return name
+}
+
+//newID - Returns a random scan id
+func newID() (string, error) {
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("writing api response")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"malscan/config"
+	"malscan/core/scan"
+	pconfig "malscan/plugins"
+	"malscan/structs"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Tests looking up reports by sha256, files are scanned on a pool with no plugins
+
+*/
+
+//scanned - Scans a file with the pool outside of the api and returns its report
+func scanned(t *testing.T, pool *scan.Pool, data string) structs.FullFileReport {
+
+	filename := filepath.Join(t.TempDir(), "sample.txt")
+	if err := ioutil.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan structs.FullFileReport, 1)
+	if !pool.Submit(filename, func(filename string, report structs.FullFileReport) { done <- report }) {
+		t.Fatal("Submit did not queue the file")
+	}
+
+	select {
+	case report := <-done:
+		return report
+	case <-time.After(30 * time.Second):
+		t.Fatal("file was not scanned")
+	}
+
+	return structs.FullFileReport{}
+}
+
+func TestGetFile(t *testing.T) {
+
+	cacheConfig := config.Values.Cache
+	t.Cleanup(func() { config.Values.Cache = cacheConfig })
+
+	config.Values.Cache.Enabled = true
+	config.Values.Cache.Dir = t.TempDir()
+
+	pool := scan.NewPool(scan.Settings{Files: 1}, pconfig.PluginConfig{})
+	t.Cleanup(func() { pool.Shutdown(time.Second) })
+
+	server, err := NewServer(pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//The file was never submitted to the api so its report can only come from the cache
+	report := scanned(t, pool, "scanned by the watcher")
+	missing := strings.Repeat("0", 64)
+
+	tests := []struct {
+		name   string
+		sha256 string
+		cache  bool
+		want   int
+	}{
+		{"cached", report.File.Sha256, true, http.StatusOK},
+		{"cached upper case", strings.ToUpper(report.File.Sha256), true, http.StatusOK},
+		{"never scanned", missing, true, http.StatusNotFound},
+		{"cache disabled", report.File.Sha256, false, http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		config.Values.Cache.Enabled = test.cache
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/files/"+test.sha256, nil))
+
+		if rec.Code != test.want {
+			t.Errorf("%s: GET /files/%s = %d, want %d", test.name, test.sha256, rec.Code, test.want)
+			continue
+		}
+
+		if rec.Code == http.StatusOK {
+			var got structs.FullFileReport
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.File.Sha256 != report.File.Sha256 {
+				t.Errorf("%s: GET /files/%s returned the report for %s", test.name, test.sha256, got.File.Sha256)
+			}
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"malscan/structs"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains the in memory store of scans submitted through the api
+
+*/
+
+const (
+	statusQueued = "queued"
+	statusDone   = "done"
+
+	defaultMaxResults = 10000
+)
+
+//submission - A sample submitted through the api
+type submission struct {
+	ID        string                  `json:"id"`
+	Status    string                  `json:"status"`
+	Filename  string                  `json:"filename"`
+	Submitted string                  `json:"submitted"`
+	Report    *structs.FullFileReport `json:"report,omitempty"`
+}
+
+//store - Holds the most recent scans so they can be polled, the oldest scans are dropped once max is reached
+type store struct {
+	mu       sync.RWMutex
+	scans    map[string]*submission
+	bySha256 map[string]string //Latest scan id for each sha256
+	order    []string          //Scan ids oldest first
+	max      int
+}
+
+func newStore(max int) *store {
+
+	if max <= 0 {
+		max = defaultMaxResults
+	}
+
+	return &store{
+		scans:    make(map[string]*submission),
+		bySha256: make(map[string]string),
+		max:      max,
+	}
+}
+
+//add - Records a newly submitted scan
+func (s *store) add(id string, filename string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scans[id] = &submission{
+		ID:        id,
+		Status:    statusQueued,
+		Filename:  filename,
+		Submitted: time.Now().Format(time.RFC3339),
+	}
+	s.order = append(s.order, id)
+
+	for len(s.order) > s.max {
+		oldest := s.order[0]
+		s.order = s.order[1:]
+		if old, ok := s.scans[oldest]; ok && old.Report != nil && s.bySha256[old.Report.File.Sha256] == oldest {
+			delete(s.bySha256, old.Report.File.Sha256)
+		}
+		delete(s.scans, oldest)
+	}
+}
+
+//remove - Drops a scan that never made it onto the queue
+func (s *store) remove(id string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.scans, id)
+	for i, queued := range s.order {
+		if queued == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+//finish - Records the report for a scan
+func (s *store) finish(id string, report structs.FullFileReport) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found, ok := s.scans[id]
+	if !ok {
+		return //Dropped while it was being scanned
+	}
+
+	found.Status = statusDone
+	found.Report = &report
+
+	if report.File.Sha256 != "" {
+		s.bySha256[report.File.Sha256] = id
+	}
+}
+
+//get - Returns a copy of a scan
+func (s *store) get(id string) (submission, bool) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	found, ok := s.scans[id]
+	if !ok {
+		return submission{}, false
+	}
+
+	return *found, true
+}
+
+//getBySha256 - Returns the most recent report for a sha256
+func (s *store) getBySha256(sha256 string) (structs.FullFileReport, bool) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.bySha256[sha256]
+	if !ok {
+		return structs.FullFileReport{}, false
+	}
+
+	return *s.scans[id].Report, true
+}
@@ -0,0 +1,297 @@
+package cache
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"malscan/config"
+	"malscan/core/utils"
+	"malscan/structs"
+
+	units "github.com/docker/go-units"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains the result cache, reports are kept by sha256 so files that were already scanned are not scanned again
+
+*/
+
+//defaultTTL - How long reports are kept when no ttl is configured
+const defaultTTL = 24 * time.Hour
+
+//Entry - A cached report along with the plugin signatures it was scanned with
+type Entry struct {
+	Report     structs.FullFileReport `json:"report"`
+	Signatures map[string]string      `json:"signatures"` //Signature of every enabled plugin when the file was scanned
+	Stored     time.Time              `json:"stored"`
+	Hits       int                    `json:"hits"` //Times the report was reused
+}
+
+//Stats - Summary of the cache
+type Stats struct {
+	Entries int
+	Expired int
+	Hits    int
+	Size    int64
+	Oldest  time.Time
+}
+
+var mutex sync.Mutex //Only one entry at a time is read or written
+
+//Enabled - Tests whether the cache is enabled in the malscan config
+func Enabled() bool {
+
+	return config.Values.Cache.Enabled == true
+}
+
+//Dir - Returns the cache directory, the configured dir or the default under the malscan base dir
+func Dir() string {
+
+	if config.Values.Cache.Dir != "" {
+		return config.Values.Cache.Dir
+	}
+
+	return utils.GetCacheDir()
+}
+
+//Get - Returns the cached report for a sha256, an entry that has expired or was scanned with different plugin signatures is removed
+//and reported as a miss
+func Get(sha256 string, signatures map[string]string) (structs.FullFileReport, bool) {
+
+	if !Enabled() || !validSha256(sha256) || signatures == nil {
+		return structs.FullFileReport{}, false
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	entry, err := load(sha256)
+	if err != nil {
+		return structs.FullFileReport{}, false
+	}
+
+	if time.Since(entry.Stored) > ttl() {
+		log.Debugf("cached report for:%s:has expired", sha256)
+		os.Remove(entryPath(sha256))
+		return structs.FullFileReport{}, false
+	}
+
+	if !equal(entry.Signatures, signatures) {
+		log.Debugf("cached report for:%s:was scanned with different plugin signatures", sha256)
+		os.Remove(entryPath(sha256))
+		return structs.FullFileReport{}, false
+	}
+
+	entry.Hits++
+	if err := save(entry); err != nil {
+		log.WithFields(log.Fields{"err": err}).Warnf("updating cached report for:%s", sha256)
+	}
+
+	return entry.Report, true
+}
+
+//Put - Responsible for caching a report along with the plugin signatures it was scanned with
+func Put(report structs.FullFileReport, signatures map[string]string) {
+
+	if !Enabled() || !validSha256(report.File.Sha256) || signatures == nil {
+		return
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	entry := Entry{Report: report, Signatures: signatures, Stored: time.Now()}
+
+	if err := save(entry); err != nil {
+		log.WithFields(log.Fields{"err": err}).Warnf("caching report for:%s", report.File.Sha256)
+	}
+}
+
+//GetStats - Returns a summary of every cached report
+func GetStats() (Stats, error) {
+
+	var stats Stats
+
+	cutoff := time.Now().Add(-ttl())
+
+	err := walk(func(path string, info os.FileInfo) {
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return
+		}
+
+		var entry Entry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return
+		}
+
+		stats.Entries++
+		stats.Hits += entry.Hits
+		stats.Size += info.Size()
+
+		if entry.Stored.Before(cutoff) {
+			stats.Expired++
+		}
+		if stats.Oldest.IsZero() || entry.Stored.Before(stats.Oldest) {
+			stats.Oldest = entry.Stored
+		}
+	})
+
+	return stats, err
+}
+
+//PrintStats - Prints a summary of the cache
+func PrintStats() error {
+
+	stats, err := GetStats()
+	if err != nil {
+		return err
+	}
+
+	oldest := "-"
+	if !stats.Oldest.IsZero() {
+		oldest = stats.Oldest.Format(time.RFC3339)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "enabled:\t%t\n", Enabled())
+	fmt.Fprintf(w, "ttl:\t%s\n", ttl())
+	fmt.Fprintf(w, "entries:\t%d\n", stats.Entries)
+	fmt.Fprintf(w, "expired:\t%d\n", stats.Expired)
+	fmt.Fprintf(w, "hits:\t%d\n", stats.Hits)
+	fmt.Fprintf(w, "size:\t%s\n", units.HumanSize(float64(stats.Size)))
+	fmt.Fprintf(w, "oldest:\t%s\n", oldest)
+
+	return w.Flush()
+}
+
+//Clear - Responsible for removing every cached report, returns how many were removed
+func Clear() (int, error) {
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	cleared := 0
+
+	err := walk(func(path string, info os.FileInfo) {
+		if err := os.Remove(path); err == nil {
+			cleared++
+		}
+	})
+
+	return cleared, err
+}
+
+//ttl - Returns the configured ttl
+func ttl() time.Duration {
+
+	if config.Values.Cache.TTL == "" {
+		return defaultTTL
+	}
+
+	age, err := utils.ParseAge(config.Values.Cache.TTL)
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Errorf("invalid cache ttl:%s:using:%s", config.Values.Cache.TTL, defaultTTL)
+		return defaultTTL
+	}
+
+	return age
+}
+
+//walk - Calls fn for every cached report
+func walk(fn func(path string, info os.FileInfo)) error {
+
+	return filepath.Walk(Dir(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(path, ".json") {
+			fn(path, info)
+		}
+		return nil
+	})
+}
+
+//entryPath - Returns where the report for a sha256 is cached, reports are spread over directories named after the first two characters of their sha256
+func entryPath(sha256 string) string {
+
+	return filepath.Join(Dir(), sha256[:2], sha256+".json")
+}
+
+//validSha256 - Tests that a sha256 is 64 hex characters so it can safely be used as a path
+func validSha256(sha256 string) bool {
+
+	decoded, err := hex.DecodeString(sha256)
+
+	return err == nil && len(decoded) == 32
+}
+
+//load - Reads a cached entry
+func load(sha256 string) (Entry, error) {
+
+	var entry Entry
+
+	data, err := ioutil.ReadFile(entryPath(sha256))
+	if err != nil {
+		return entry, err
+	}
+
+	err = json.Unmarshal(data, &entry)
+
+	return entry, err
+}
+
+//save - Writes a cached entry under a temporary name and renames it so a partial entry is never read
+func save(entry Entry) error {
+
+	path := entryPath(entry.Report.File.Sha256)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "error while marshaling cached report")
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+//equal - Tests whether two sets of plugin signatures are the same
+func equal(a map[string]string, b map[string]string) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for name, signature := range a {
+		if other, ok := b[name]; !ok || other != signature {
+			return false
+		}
+	}
+
+	return true
+}
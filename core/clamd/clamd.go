@@ -0,0 +1,221 @@
+package clamd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains a client for the clamd protocol over a unix socket or tcp
+
+*/
+
+const (
+	chunkSize      = 64 * 1024
+	defaultTimeout = time.Minute
+)
+
+//Client - Talks to a single clamd over a unix socket or tcp, a new connection is made for every command
+type Client struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+//Result - The verdict clamd returned for a stream
+type Result struct {
+	Infected  bool
+	Signature string //Name of the signature that matched, empty when the stream is clean
+}
+
+//Version - The engine and signature database versions clamd is running
+type Version struct {
+	Engine       string //ClamAV engine version, for example 0.103.2
+	Database     string //Signature database version, for example 26190
+	DatabaseDate string //When the signature database was built
+	Raw          string //The full response to VERSION
+}
+
+//NewClient - Responsible for creating a client for the address passed in, addresses are either
+//unix:///path/to/clamd.sock, tcp://host:port or a bare socket path or host:port
+//timeout applies to each command and defaults to a minute when 0
+func NewClient(address string, timeout time.Duration) (*Client, error) {
+
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	client := &Client{timeout: timeout}
+
+	switch {
+	case strings.HasPrefix(address, "unix://"):
+		client.network, client.address = "unix", strings.TrimPrefix(address, "unix://")
+	case strings.HasPrefix(address, "tcp://"):
+		client.network, client.address = "tcp", strings.TrimPrefix(address, "tcp://")
+	case strings.HasPrefix(address, "/"):
+		client.network, client.address = "unix", address
+	case address != "":
+		client.network, client.address = "tcp", address
+	default:
+		return nil, errors.New("no clamd address set")
+	}
+
+	return client, nil
+}
+
+//Ping - Checks clamd is up
+func (client *Client) Ping() error {
+
+	reply, err := client.command("PING", nil)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return errors.New("unexpected reply to PING: " + reply)
+	}
+
+	return nil
+}
+
+//Version - Returns the engine and signature database versions clamd is running
+func (client *Client) Version() (Version, error) {
+
+	reply, err := client.command("VERSION", nil)
+	if err != nil {
+		return Version{}, err
+	}
+
+	return parseVersion(reply), nil
+}
+
+//Reload - Asks clamd to reload its signature database
+func (client *Client) Reload() error {
+
+	reply, err := client.command("RELOAD", nil)
+	if err != nil {
+		return err
+	}
+	if reply != "RELOADING" {
+		return errors.New("unexpected reply to RELOAD: " + reply)
+	}
+
+	return nil
+}
+
+//InStream - Streams the contents of r to clamd to be scanned
+func (client *Client) InStream(r io.Reader) (Result, error) {
+
+	reply, err := client.command("INSTREAM", func(conn net.Conn) error {
+
+		buf := make([]byte, chunkSize)
+		size := make([]byte, 4)
+
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				binary.BigEndian.PutUint32(size, uint32(n))
+				if _, err := conn.Write(size); err != nil {
+					return err
+				}
+				if _, err := conn.Write(buf[:n]); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		//A zero length chunk marks the end of the stream
+		binary.BigEndian.PutUint32(size, 0)
+		_, err := conn.Write(size)
+		return err
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	return parseScanReply(reply)
+}
+
+//command - Sends a single null terminated command and returns clamds reply, send is used to write anything that follows the command
+func (client *Client) command(name string, send func(conn net.Conn) error) (string, error) {
+
+	log.Debugf("sending clamd command:%s:to:%s", name, client.address)
+
+	conn, err := net.DialTimeout(client.network, client.address, client.timeout)
+	if err != nil {
+		return "", errors.Wrap(err, "error while connecting to clamd at: "+client.address)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(client.timeout))
+
+	if _, err := conn.Write([]byte("z" + name + "\x00")); err != nil {
+		return "", errors.Wrap(err, "error while sending clamd command: "+name)
+	}
+
+	if send != nil {
+		if err := send(conn); err != nil {
+			return "", errors.Wrap(err, "error while sending data for clamd command: "+name)
+		}
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString('\x00')
+	if err != nil && !(err == io.EOF && reply != "") {
+		return "", errors.Wrap(err, "error while reading reply to clamd command: "+name)
+	}
+
+	return strings.TrimSpace(strings.TrimRight(reply, "\x00")), nil
+}
+
+//parseScanReply - Parses a reply such as "stream: OK", "stream: Eicar-Signature FOUND" or "... ERROR"
+func parseScanReply(reply string) (Result, error) {
+
+	//Replies to INSTREAM are prefixed with "stream: "
+	if i := strings.Index(reply, ": "); i >= 0 {
+		reply = reply[i+2:]
+	}
+
+	switch {
+	case reply == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSuffix(reply, " FOUND")}, nil
+	case strings.HasSuffix(reply, " ERROR"):
+		return Result{}, errors.New("clamd error: " + strings.TrimSuffix(reply, " ERROR"))
+	}
+
+	return Result{}, errors.New("unexpected reply from clamd: " + reply)
+}
+
+//parseVersion - Parses a reply to VERSION such as "ClamAV 0.103.2/26190/Thu Jun  3 09:57:05 2021"
+func parseVersion(reply string) Version {
+
+	version := Version{Raw: reply}
+
+	parts := strings.SplitN(reply, "/", 3)
+
+	version.Engine = strings.TrimSpace(strings.TrimPrefix(parts[0], "ClamAV"))
+	if len(parts) > 1 {
+		version.Database = parts[1]
+	}
+	if len(parts) > 2 {
+		version.DatabaseDate = parts[2]
+	}
+
+	return version
+}
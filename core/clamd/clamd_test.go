@@ -0,0 +1,256 @@
+package clamd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Tests the clamd client against a fake clamd listening on tcp
+
+*/
+
+//fakeClamd - A clamd that answers each command with reply, streams sent with INSTREAM are kept in streamed
+type fakeClamd struct {
+	listener net.Listener
+	reply    func(command string, stream []byte) string
+	commands chan string
+	streamed chan []byte
+}
+
+//newFakeClamd - Starts a fake clamd on a free local port, it is stopped when the test finishes
+func newFakeClamd(t *testing.T, reply func(command string, stream []byte) string) *fakeClamd {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeClamd{
+		listener: listener,
+		reply:    reply,
+		commands: make(chan string, 10),
+		streamed: make(chan []byte, 10),
+	}
+
+	go fake.serve()
+
+	t.Cleanup(func() { listener.Close() })
+
+	return fake
+}
+
+func (fake *fakeClamd) serve() {
+
+	for {
+		conn, err := fake.listener.Accept()
+		if err != nil {
+			return
+		}
+		go fake.handle(conn)
+	}
+}
+
+//handle - Reads one null terminated command, and the chunks that follow INSTREAM, and writes the reply
+func (fake *fakeClamd) handle(conn net.Conn) {
+
+	defer conn.Close()
+
+	br := bufio.NewReader(conn)
+
+	command, err := br.ReadString('\x00')
+	if err != nil {
+		return
+	}
+	command = strings.TrimSuffix(command, "\x00")
+	fake.commands <- command
+
+	var stream []byte
+	if command == "zINSTREAM" {
+		size := make([]byte, 4)
+		for {
+			if _, err := io.ReadFull(br, size); err != nil {
+				return
+			}
+			n := binary.BigEndian.Uint32(size)
+			if n == 0 {
+				break
+			}
+			chunk := make([]byte, n)
+			if _, err := io.ReadFull(br, chunk); err != nil {
+				return
+			}
+			stream = append(stream, chunk...)
+		}
+		fake.streamed <- stream
+	}
+
+	conn.Write([]byte(fake.reply(command, stream) + "\x00"))
+}
+
+//client - Returns a client for the fake clamd
+func (fake *fakeClamd) client(t *testing.T) *Client {
+
+	client, err := NewClient("tcp://"+fake.listener.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+func TestPing(t *testing.T) {
+
+	fake := newFakeClamd(t, func(command string, stream []byte) string { return "PONG" })
+
+	if err := fake.client(t).Ping(); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if command := <-fake.commands; command != "zPING" {
+		t.Fatalf("sent %q, want zPING", command)
+	}
+
+	wrong := newFakeClamd(t, func(command string, stream []byte) string { return "PANG" })
+
+	if err := wrong.client(t).Ping(); err == nil {
+		t.Fatal("Ping accepted a reply other than PONG")
+	}
+}
+
+func TestVersion(t *testing.T) {
+
+	tests := []struct {
+		reply string
+		want  Version
+	}{
+		{
+			"ClamAV 0.103.2/26190/Thu Jun  3 09:57:05 2021",
+			Version{Engine: "0.103.2", Database: "26190", DatabaseDate: "Thu Jun  3 09:57:05 2021"},
+		},
+		{
+			"ClamAV 0.103.2",
+			Version{Engine: "0.103.2"},
+		},
+	}
+
+	for _, test := range tests {
+		fake := newFakeClamd(t, func(command string, stream []byte) string { return test.reply })
+
+		got, err := fake.client(t).Version()
+		if err != nil {
+			t.Fatalf("Version: %v", err)
+		}
+		if command := <-fake.commands; command != "zVERSION" {
+			t.Fatalf("sent %q, want zVERSION", command)
+		}
+
+		test.want.Raw = test.reply
+		if got != test.want {
+			t.Errorf("Version of %q = %+v, want %+v", test.reply, got, test.want)
+		}
+	}
+}
+
+func TestInStream(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		reply    string
+		infected bool
+		sig      string
+		err      string
+	}{
+		{"clean", "stream: OK", false, "", ""},
+		{"found", "stream: Eicar-Signature FOUND", true, "Eicar-Signature", ""},
+		{"error", "stream: Can't allocate memory ERROR", false, "", "Can't allocate memory"},
+		{"size limit", "INSTREAM size limit exceeded. ERROR", false, "", "size limit exceeded"},
+		{"unexpected", "stream: maybe", false, "", "unexpected reply"},
+	}
+
+	//Larger than one chunk so the stream is split
+	sample := bytes.Repeat([]byte("malscan"), chunkSize/4)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			fake := newFakeClamd(t, func(command string, stream []byte) string { return test.reply })
+
+			result, err := fake.client(t).InStream(bytes.NewReader(sample))
+
+			if command := <-fake.commands; command != "zINSTREAM" {
+				t.Fatalf("sent %q, want zINSTREAM", command)
+			}
+			if streamed := <-fake.streamed; !bytes.Equal(streamed, sample) {
+				t.Fatalf("clamd received %d bytes, want %d", len(streamed), len(sample))
+			}
+
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("InStream error = %v, want one containing %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("InStream: %v", err)
+			}
+			if result.Infected != test.infected || result.Signature != test.sig {
+				t.Errorf("InStream = %+v, want infected %v signature %q", result, test.infected, test.sig)
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+
+	fake := newFakeClamd(t, func(command string, stream []byte) string { return "RELOADING" })
+
+	if err := fake.client(t).Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if command := <-fake.commands; command != "zRELOAD" {
+		t.Fatalf("sent %q, want zRELOAD", command)
+	}
+
+	wrong := newFakeClamd(t, func(command string, stream []byte) string { return "NOPE" })
+
+	if err := wrong.client(t).Reload(); err == nil {
+		t.Fatal("Reload accepted a reply other than RELOADING")
+	}
+}
+
+func TestNewClient(t *testing.T) {
+
+	tests := []struct {
+		address string
+		network string
+		addr    string
+	}{
+		{"unix:///var/run/clamav/clamd.ctl", "unix", "/var/run/clamav/clamd.ctl"},
+		{"tcp://127.0.0.1:3310", "tcp", "127.0.0.1:3310"},
+		{"/tmp/clamd.sock", "unix", "/tmp/clamd.sock"},
+		{"clamd:3310", "tcp", "clamd:3310"},
+	}
+
+	for _, test := range tests {
+		client, err := NewClient(test.address, 0)
+		if err != nil {
+			t.Fatalf("NewClient(%q): %v", test.address, err)
+		}
+		if client.network != test.network || client.address != test.addr {
+			t.Errorf("NewClient(%q) = %s %s, want %s %s", test.address, client.network, client.address, test.network, test.addr)
+		}
+	}
+
+	if _, err := NewClient("", 0); err == nil {
+		t.Error("NewClient accepted an empty address")
+	}
+}
@@ -1,7 +1,10 @@
 package docker
 
 import (
+	"sync"
+
 	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -16,14 +19,22 @@ Purpose: Initialize docker API
 var cli *client.Client //Docker client used to make calls down to the docker engine
 var err error
 
-//init - Responsible for initializing a new docker client
-//The docker client is used to interact with the docker daemon
-func init() {
+var connectOnce sync.Once
+
+//connect - Responsible for lazily initializing a new docker client the first time docker is needed
+//The docker client is used to interact with the docker daemon, hosts that only run non docker plugins never create one
+func connect() error {
+
+	connectOnce.Do(func() {
+
+		log.Debug("initializing docker client")
 
-	log.Debug("initializing docker client")
+		cli, err = client.NewEnvClient()
+		if err != nil {
+			log.WithFields(log.Fields{"err": err}).Error("creating docker client")
+			err = errors.Wrap(err, "error while creating docker client")
+		}
+	})
 
-	cli, err = client.NewEnvClient()
-	if err != nil {
-		log.WithFields(log.Fields{"err": err}).Fatal("creating docker client")
-	}
+	return err
 }
@@ -2,15 +2,18 @@ package docker
 
 import (
 	"bytes"
+	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
-	"malscan/config"
-	"malscan/core/utils"
+	file "malscan/core/utils/file"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/pkg/stdcopy"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
 )
@@ -23,108 +26,246 @@ Purpose: Contains all container related functions
 
 */
 
+//RunOptions - Limits applied to a container ran against a file, zero values leave the docker defaults in place
+//and the hardened sandbox profile enabled
+type RunOptions struct {
+	Timeout   time.Duration //How long the container can run before it is killed
+	Memory    int64         //Memory limit in bytes
+	NanoCPUs  int64         //CPU quota in units of 10^-9 CPUs
+	PidsLimit int64         //Maximum amount of processes in the container
+
+	AllowNetwork       bool //Use the default network instead of no network
+	WritableSample     bool //Mount a writable copy of the file instead of a read only one
+	WritableRootfs     bool //Keep the root filesystem writable
+	KeepCapabilities   bool //Keep the default capabilities instead of dropping all of them
+	AllowNewPrivileges bool //Allow processes to gain privileges (setuid binaries etc.)
+	DisableSeccomp     bool //Do not apply the seccomp profile set in the malscan config
+
+	ScanID string //Scan the container is labelled with
+	Plugin string //Plugin the container is labelled with
+}
+
+//ErrTimeout - Returned when a container ran against a file is killed for running longer than its timeout
+var ErrTimeout = errors.New("container timed out")
+
+//ErrCancelled - Returned when a container ran against a file is killed because its context was cancelled
+var ErrCancelled = errors.New("container cancelled")
+
+//Result - Output of a container ran against a file
+type Result struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int64
+	ImageID  string //Image the container was actually created from
+}
+
 //RunContainerOnFile - Used to run whatever image is passed into the function as a container against the file
-//passed onto the function, returns the results of the scan as a slice of bytes
-func RunContainerOnFile(image string, fileToScanName *string) []byte {
+//passed onto the function, returns the stdout, stderr and exit code of the container
+//If the container runs longer than the timeout in options it is killed, removed and ErrTimeout is returned,
+//if ctx is cancelled first it is killed, removed and ErrCancelled is returned
+func RunContainerOnFile(ctx context.Context, image string, fileToScanName *string, options RunOptions) (result Result, err error) {
 
 	log.Debugf("running container:%s:against file:%s", image, *fileToScanName)
 
-	var fileDirOnDisk string
-	command := filepath.Join("/malware", *fileToScanName)
-	if config.Values.Env.Filestore == "" {
-		fileDirOnDisk = utils.GetFilestoreDir() //If filestore has not be set in the config file then use default filestore
-	} else {
-		fileDirOnDisk = config.Values.Env.Filestore //If filestore is set use the user provided path in config file
+	if ctx.Err() != nil {
+		return result, ErrCancelled
 	}
 
-	resp, err := cli.ContainerCreate(context.Background(), &container.Config{
-		Image: image,
-		Cmd:   []string{command},
-		Tty:   true,
-	}, &container.HostConfig{
-		Mounts: []mount.Mount{
-			{
-				Type:   mount.TypeBind,
-				Source: fileDirOnDisk,
-				Target: "/malware",
-			},
+	if err := connect(); err != nil {
+		return result, err
+	}
+
+	//Only the file being scanned is mounted into the container, never the whole filestore
+	fileOnDisk := file.Path(*fileToScanName)
+	command := filepath.Join("/malware", filepath.Base(fileOnDisk))
+
+	scanDir, err := stageFile(fileOnDisk, options.WritableSample)
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Errorf("staging file for:%s", image)
+		return result, err
+	}
+	defer os.RemoveAll(scanDir)
+
+	return runContainer(ctx, image, []string{command}, scanDir, *fileToScanName, options)
+}
+
+//RunContainerCommand - Used to run a command such as version in a container of the image passed in, no file is mounted
+//but the container is otherwise ran the same way as RunContainerOnFile. Returns the stdout, stderr and exit code of the container
+func RunContainerCommand(ctx context.Context, image string, cmd []string, options RunOptions) (result Result, err error) {
+
+	log.Debugf("running container:%s:command:%v", image, cmd)
+
+	if ctx.Err() != nil {
+		return result, ErrCancelled
+	}
+
+	if err := connect(); err != nil {
+		return result, err
+	}
+
+	return runContainer(ctx, image, cmd, "", strings.Join(cmd, " "), options)
+}
+
+//runContainer - Creates, runs and removes a container with the hardened profile, scanDir is mounted at /malware when set.
+//target names what the container is ran against in logs
+func runContainer(ctx context.Context, image string, cmd []string, scanDir string, target string, options RunOptions) (result Result, err error) {
+
+	hostConfig := &container.HostConfig{
+		Resources: container.Resources{
+			Memory:   options.Memory,
+			NanoCPUs: options.NanoCPUs,
 		},
-	}, nil, &v1.Platform{Architecture: "amd64", OS: "linux"}, "")
+	}
+	if options.PidsLimit > 0 {
+		hostConfig.Resources.PidsLimit = &options.PidsLimit
+	}
+
+	hardenHostConfig(hostConfig, scanDir, options)
+
+	//Containers are created and started without ctx so a cancel can never leave a container docker created but malscan does not know about
+	resp, err := cli.ContainerCreate(context.Background(), &container.Config{
+		Image:  image,
+		Cmd:    cmd,
+		Labels: labels(options.ScanID, options.Plugin),
+	}, hostConfig, nil, &v1.Platform{Architecture: "amd64", OS: "linux"}, "")
 	if err != nil {
 		log.WithFields(log.Fields{"err": err}).Errorf("creating container for:%s", image)
+		return result, errors.Wrap(err, "error while creating container for: "+image)
+	}
+
+	//Always clean up the container, even if it could not be started or timed out
+	defer func() {
+		err := cli.ContainerRemove(context.Background(), resp.ID, types.ContainerRemoveOptions{
+			Force: true,
+		})
+		if err != nil {
+			log.WithFields(log.Fields{"err": err}).Errorf("removing container:%s", image)
+		}
+	}()
+
+	//The tag may be moved by an update at any time so the image the container was created from is recorded
+	if inspect, err := cli.ContainerInspect(context.Background(), resp.ID); err == nil {
+		result.ImageID = inspect.Image
+	} else {
+		log.WithFields(log.Fields{"err": err}).Errorf("inspecting container for:%s", image)
 	}
 
 	if err := cli.ContainerStart(context.Background(), resp.ID, types.ContainerStartOptions{}); err != nil {
 		log.WithFields(log.Fields{"err": err}).Errorf("starting container for:%s", image)
+		return result, errors.Wrap(err, "error while starting container for: "+image)
 	}
 
-	cli.ContainerWait(context.Background(), resp.ID, container.WaitConditionNotRunning)
+	waitCtx := ctx
+	if options.Timeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(waitCtx, options.Timeout)
+		defer cancel()
+	}
 
-	/*if err != nil {
-		log.Error(errors.Wrap(err, "waiting for container, image: "+image))
+	result.ExitCode = -1
+
+	statusCh, errCh := cli.ContainerWait(waitCtx, resp.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		if waitCtx.Err() != nil {
+			if err := cli.ContainerKill(context.Background(), resp.ID, "KILL"); err != nil {
+				log.WithFields(log.Fields{"err": err}).Errorf("killing container:%s", image)
+			}
+			if ctx.Err() != nil {
+				log.Warnf("container:%s:cancelled against:%s", image, target)
+				return result, ErrCancelled
+			}
+			log.Warnf("container:%s:timed out after:%s:against:%s", image, options.Timeout, target)
+			return result, ErrTimeout
+		}
+		log.WithFields(log.Fields{"err": err}).Errorf("waiting for container:%s", image)
+	case status := <-statusCh:
+		result.ExitCode = status.StatusCode
 	}
-	*/
 
 	out, err := cli.ContainerLogs(context.Background(), resp.ID, types.ContainerLogsOptions{
 		ShowStdout: true,
+		ShowStderr: true,
 		Follow:     true,
 	})
 	if err != nil {
 		log.WithFields(log.Fields{"err": err}).Errorf("getting logs for:%s", image)
+		return result, errors.Wrap(err, "error while getting logs for: "+image)
 	}
 
-	buf := new(bytes.Buffer)
+	//Without a tty docker multiplexes stdout and stderr into one stream
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
 
-	buf.ReadFrom(out)
+	_, err = stdcopy.StdCopy(stdout, stderr, out)
 
 	out.Close()
 
-	err = cli.ContainerRemove(context.Background(), resp.ID, types.ContainerRemoveOptions{
-		Force: true,
-	})
-
 	if err != nil {
-		log.WithFields(log.Fields{"err": err}).Errorf("removing container:%s", image)
+		log.WithFields(log.Fields{"err": err}).Errorf("reading logs for:%s", image)
+		return result, errors.Wrap(err, "error while reading logs for: "+image)
 	}
 
-	log.Debugf("finished running container:%s:against file:%s", image, *fileToScanName)
+	result.Stdout = stdout.Bytes()
+	result.Stderr = stderr.Bytes()
+
+	log.Debugf("finished running container:%s:against:%s:exit code:%d", image, target, result.ExitCode)
 
-	return (buf.Bytes())
+	return result, nil
 }
 
 //RunContainerUpdate - Responsible for accepting an image, spawning the container and running the update command for that image/plugin.
 //Once the update command has fully run in the container the container is then commited back to an image.
-func RunContainerUpdate(image string) (outcome string) {
+//The image is tagged as :previous before it is overwritten so the update can be rolled back, an update that fails is never committed.
+//The container is labelled with the plugin it updates. An update still running after timeout is killed and removed and fails
+func RunContainerUpdate(image string, plugin string, timeout time.Duration) (outcome string) {
 
 	log.Debugf("running container:%s:update", image)
 
+	if err := connect(); err != nil {
+		return "failure"
+	}
+
 	resp, err := cli.ContainerCreate(context.Background(), &container.Config{
-		Image: image,
-		Cmd:   []string{"update"},
-		Tty:   true,
+		Image:  image,
+		Cmd:    []string{"update"},
+		Tty:    true,
+		Labels: labels("", plugin),
 	}, nil, nil, &v1.Platform{Architecture: "amd64", OS: "linux"}, "")
 	if err != nil {
 		log.WithFields(log.Fields{"err": err}).Errorf("creating container for:%s", image)
+		return "failure"
 	}
 
+	defer func() {
+		err := cli.ContainerRemove(context.Background(), resp.ID, types.ContainerRemoveOptions{
+			Force: true,
+		})
+		if err != nil {
+			log.WithFields(log.Fields{"err": err}).Errorf("removing container:%s", image)
+		}
+	}()
+
 	if err := cli.ContainerStart(context.Background(), resp.ID, types.ContainerStartOptions{}); err != nil {
 		log.WithFields(log.Fields{"err": err}).Errorf("starting container for:%s", image)
+		return "failure"
 	}
 
-	cli.ContainerWait(context.Background(), resp.ID, container.WaitConditionNotRunning)
-
-	/*if err != nil {
-		log.Error(err)
-	}
-	*/
-
-	image = filepath.Join("docker.io/", image)
-
-	com, err := cli.ContainerCommit(context.Background(), resp.ID, types.ContainerCommitOptions{Reference: image})
-	if err != nil {
-		log.WithFields(log.Fields{"err": err}).Errorf("committing container for:%s", image)
-	} else {
-		log.Debugf("committed updated container:%s:ID:%s ", image, com.ID)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var exitCode int64
+	statusCh, errCh := cli.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		if ctx.Err() == context.DeadlineExceeded {
+			log.Errorf("update of:%s:timed out after:%s:killing it", image, timeout)
+			return "failure"
+		}
+		log.WithFields(log.Fields{"err": err}).Errorf("waiting for container:%s", image)
+		return "failure"
+	case status := <-statusCh:
+		exitCode = status.StatusCode
 	}
 
 	out, err := cli.ContainerLogs(context.Background(), resp.ID, types.ContainerLogsOptions{
@@ -133,6 +274,7 @@ func RunContainerUpdate(image string) (outcome string) {
 	})
 	if err != nil {
 		log.WithFields(log.Fields{"err": err}).Errorf("getting logs for:%s", image)
+		return "failure"
 	}
 
 	buf := new(bytes.Buffer)
@@ -141,20 +283,28 @@ func RunContainerUpdate(image string) (outcome string) {
 
 	out.Close()
 
-	err = cli.ContainerRemove(context.Background(), resp.ID, types.ContainerRemoveOptions{
-		Force: true,
-	})
+	//Update commands print 1 first when they fail
+	if exitCode != 0 || (buf.Len() > 0 && buf.Bytes()[0] == '1') {
+		log.Warnf("update of:%s:failed:exit code:%d", image, exitCode)
+		return "failure"
+	}
 
-	if err != nil {
-		log.WithFields(log.Fields{"err": err}).Errorf("removing container:%s", image)
+	if err := TagPrevious(image); err != nil {
+		log.WithFields(log.Fields{"err": err}).Errorf("saving previous image of:%s", image)
+		return "failure"
 	}
 
-	if string(buf.Bytes()[0]) != "1" {
-		return "success"
+	reference := filepath.Join("docker.io/", image)
+
+	com, err := cli.ContainerCommit(context.Background(), resp.ID, types.ContainerCommitOptions{Reference: reference})
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Errorf("committing container for:%s", reference)
+		return "failure"
 	}
 
-	log.Debugf("finished running container:%s:update", image)
+	log.Debugf("committed updated container:%s:ID:%s ", reference, com.ID)
 
-	return "failure"
+	log.Debugf("finished running container:%s:update", image)
 
+	return "success"
 }
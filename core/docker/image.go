@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"os"
+	"strings"
 
 	"github.com/docker/docker/api/types"
 	"github.com/pkg/errors"
@@ -25,6 +26,11 @@ func PullImage(image string) {
 
 	log.Debug("PullImage - Pulling image")
 
+	if err := connect(); err != nil {
+		log.Error(err)
+		return
+	}
+
 	out, err := cli.ImagePull(context.Background(), image, types.ImagePullOptions{})
 	if err != nil {
 		log.Error(err)
@@ -42,6 +48,11 @@ func GetIntalledImages() (installedImages []types.ImageSummary) {
 
 	log.Debug("finding installed images")
 
+	if err := connect(); err != nil {
+		log.Error(err)
+		return nil
+	}
+
 	images, err := cli.ImageList(context.Background(), types.ImageListOptions{})
 	if err != nil {
 		log.Error(errors.Wrap(err, "error while listing docker images"))
@@ -51,3 +62,92 @@ func GetIntalledImages() (installedImages []types.ImageSummary) {
 
 	return installedImages
 }
+
+//ImageID - Returns the ID of the image passed in, the ID changes whenever the image is updated
+func ImageID(image string) (string, error) {
+
+	if err := connect(); err != nil {
+		return "", err
+	}
+
+	inspect, _, err := cli.ImageInspectWithRaw(context.Background(), image)
+	if err != nil {
+		return "", errors.Wrap(err, "error while inspecting image: "+image)
+	}
+
+	return inspect.ID, nil
+}
+
+//PreviousTag - Returns the tag an image is saved under before it is updated, a digest the image is pinned to is dropped
+//as a digest can not be tagged
+func PreviousTag(image string) string {
+
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+
+	//Only a colon after the last slash starts a tag, one before it is a registry port
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+
+	return image + ":previous"
+}
+
+//NormalizeTag - Returns the reference docker lists an image under so two names for the same image compare equal.
+//Docker Hub names lose their docker.io and library/ prefixes, :latest is implied when there is no tag
+//and an image pinned to a digest is named repo@digest the way docker lists repo digests
+func NormalizeTag(image string) string {
+
+	digest := ""
+	if i := strings.Index(image, "@"); i >= 0 {
+		image, digest = image[:i], image[i:]
+	}
+
+	for _, prefix := range []string{"docker.io/", "index.docker.io/"} {
+		image = strings.TrimPrefix(image, prefix)
+	}
+	image = strings.TrimPrefix(image, "library/")
+
+	//Only a colon after the last slash starts a tag, one before it is a registry port
+	tag := ":latest"
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image, tag = image[:i], image[i:]
+	}
+
+	if digest != "" {
+		return image + digest
+	}
+
+	return image + tag
+}
+
+//TagPrevious - Responsible for tagging the current image as :previous so an update can be rolled back
+func TagPrevious(image string) error {
+
+	if err := connect(); err != nil {
+		return err
+	}
+
+	if err := cli.ImageTag(context.Background(), image, PreviousTag(image)); err != nil {
+		return errors.Wrap(err, "error while tagging previous image of: "+image)
+	}
+
+	return nil
+}
+
+//RestorePrevious - Responsible for rolling an image back to the image tagged :previous before it was updated
+func RestorePrevious(image string) error {
+
+	if err := connect(); err != nil {
+		return err
+	}
+
+	if err := cli.ImageTag(context.Background(), PreviousTag(image), image); err != nil {
+		return errors.Wrap(err, "error while restoring previous image of: "+image)
+	}
+
+	log.Warnf("rolled back:%s:to:%s", image, PreviousTag(image))
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package docker
+
+import "testing"
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Tests the tag images are saved under before they are updated and how image names are compared
+
+*/
+
+func TestPreviousTag(t *testing.T) {
+
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{"malscan/clamav", "malscan/clamav:previous"},
+		{"malscan/clamav:latest", "malscan/clamav:previous"},
+		{"registry:5000/malscan/clamav", "registry:5000/malscan/clamav:previous"},
+		{"registry:5000/malscan/clamav:1.0", "registry:5000/malscan/clamav:previous"},
+		{"malscan/clamav@sha256:abc123", "malscan/clamav:previous"},
+		{"registry:5000/malscan/clamav:1.0@sha256:abc123", "registry:5000/malscan/clamav:previous"},
+	}
+
+	for _, test := range tests {
+		if got := PreviousTag(test.image); got != test.want {
+			t.Errorf("PreviousTag(%q) = %q, want %q", test.image, got, test.want)
+		}
+	}
+}
+
+func TestNormalizeTag(t *testing.T) {
+
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{"malscan/clamav", "malscan/clamav:latest"},
+		{"malscan/clamav:latest", "malscan/clamav:latest"},
+		{"malscan/clamav:previous", "malscan/clamav:previous"},
+		{"malscan/clamav:1.0", "malscan/clamav:1.0"},
+		{"docker.io/malscan/clamav", "malscan/clamav:latest"},
+		{"docker.io/library/ubuntu:20.04", "ubuntu:20.04"},
+		{"library/ubuntu", "ubuntu:latest"},
+		{"registry:5000/malscan/clamav", "registry:5000/malscan/clamav:latest"},
+		{"registry:5000/malscan/clamav:1.0", "registry:5000/malscan/clamav:1.0"},
+		{"malscan/clamav@sha256:abc123", "malscan/clamav@sha256:abc123"},
+		{"malscan/clamav:1.0@sha256:abc123", "malscan/clamav@sha256:abc123"},
+	}
+
+	for _, test := range tests {
+		if got := NormalizeTag(test.image); got != test.want {
+			t.Errorf("NormalizeTag(%q) = %q, want %q", test.image, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package docker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"malscan/config"
+	"malscan/core/lease"
+	"malscan/core/utils"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains functions related to labelling and finding the containers malscan creates
+
+*/
+
+//Labels set on every container malscan creates, containers are only ever found by these labels
+//so other containers on the docker daemon are never touched
+const (
+	LabelScanID   = "malscan.scan_id"  //Scan the container was ran for, empty for update containers
+	LabelPlugin   = "malscan.plugin"   //Plugin the container was ran for
+	LabelInstance = "malscan.instance" //Malscan that created the container
+	LabelPID      = "malscan.pid"      //Process of the malscan that created the container, only informational as pids are reused
+	LabelProcess  = "malscan.process"  //Random id of the malscan process that created the container
+	LabelStarted  = "malscan.started"  //When the container was created (RFC3339)
+)
+
+//processLeaseOnce - The process lease is taken the first time a container is created
+var processLeaseOnce sync.Once
+
+//Container - A container created by malscan, as described by its labels
+type Container struct {
+	ID      string    `json:"id"`
+	Image   string    `json:"image"`
+	State   string    `json:"state"`
+	ScanID  string    `json:"scan_id,omitempty"`
+	Plugin  string    `json:"plugin"`
+	PID     int       `json:"pid"`
+	Process string    `json:"process,omitempty"`
+	Started time.Time `json:"started"`
+}
+
+//Instance - Returns the name containers created by this malscan are labelled with, set with instance in the malscan config
+func Instance() string {
+
+	if config.Values.Sandbox.Instance != "" {
+		return config.Values.Sandbox.Instance
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "malscan"
+	}
+
+	return hostname
+}
+
+//processLease - Returns the path of the lease held by the malscan process with the id passed in while it can have containers
+func processLease(id string) string {
+
+	return filepath.Join(utils.GetRunDir(), "process-"+id+".lease")
+}
+
+//labels - Returns the labels set on a container created for a plugin, the process lease is taken so other malscans
+//can tell the container still has a malscan that will remove it
+func labels(scanID string, plugin string) map[string]string {
+
+	processLeaseOnce.Do(func() {
+		if _, err := lease.Acquire(processLease(lease.ID)); err != nil {
+			log.WithFields(log.Fields{"err": err}).Error("taking process lease")
+		}
+	})
+
+	return map[string]string{
+		LabelScanID:   scanID,
+		LabelPlugin:   plugin,
+		LabelInstance: Instance(),
+		LabelPID:      strconv.Itoa(os.Getpid()),
+		LabelProcess:  lease.ID,
+		LabelStarted:  time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+//ListContainers - Returns every container, running or not, labelled as created by this malscan instance
+func ListContainers() ([]Container, error) {
+
+	if err := connect(); err != nil {
+		return nil, err
+	}
+
+	found, err := cli.ContainerList(context.Background(), types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", LabelInstance+"="+Instance())),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error while listing malscan containers")
+	}
+
+	containers := make([]Container, 0, len(found))
+	for _, summary := range found {
+		pid, _ := strconv.Atoi(summary.Labels[LabelPID])
+		started, err := time.Parse(time.RFC3339, summary.Labels[LabelStarted])
+		if err != nil {
+			started = time.Unix(summary.Created, 0)
+		}
+		containers = append(containers, Container{
+			ID:      summary.ID,
+			Image:   summary.Image,
+			State:   summary.State,
+			ScanID:  summary.Labels[LabelScanID],
+			Plugin:  summary.Labels[LabelPlugin],
+			PID:     pid,
+			Process: summary.Labels[LabelProcess],
+			Started: started,
+		})
+	}
+
+	return containers, nil
+}
+
+//Orphaned - Tests whether the malscan that created the container is no longer running, nothing will ever remove the container.
+//A malscan is running while it keeps its process lease fresh, so every malscan of an instance must share the run dir.
+//Containers created before the process label was added are only removed once they outlive their timeout
+func (c Container) Orphaned() bool {
+
+	if c.Process == "" {
+		return false
+	}
+
+	if c.Process == lease.ID {
+		return false
+	}
+
+	return !lease.Held(processLease(c.Process))
+}
+
+//SweepLeases - Removes the process leases left by malscans that are no longer running
+func SweepLeases() {
+
+	lease.Sweep(processLease("*"))
+}
+
+//RemoveContainer - Responsible for killing and removing a container created by malscan
+func RemoveContainer(id string) error {
+
+	if err := connect(); err != nil {
+		return err
+	}
+
+	err := cli.ContainerRemove(context.Background(), id, types.ContainerRemoveOptions{Force: true})
+	if err != nil {
+		return errors.Wrap(err, "error while removing container: "+id)
+	}
+
+	return nil
+}
@@ -17,12 +17,17 @@ Purpose: Contains functions related to cleaning the docker system
 
 //Prune - This function simply simply makes a call to the docker engine
 //requesting that all left over container data is cleaned up
-//BE VERY CAREFUL WHEN USING THIS FUNCTION (ALL CONTAINERS GET MARKED FOR REMOVAL)
+//Only stopped containers labelled as created by this malscan instance are removed
 func Prune() {
 
 	log.Debug("cleaning up containers")
 
-	_, err := cli.ContainersPrune(context.Background(), filters.NewArgs())
+	if err := connect(); err != nil {
+		log.Error(err)
+		return
+	}
+
+	_, err := cli.ContainersPrune(context.Background(), filters.NewArgs(filters.Arg("label", LabelInstance+"="+Instance())))
 	if err != nil {
 		log.Error(err)
 	}
@@ -0,0 +1,149 @@
+package docker
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"malscan/config"
+	"malscan/core/utils"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains functions related to isolating analysis containers from the host and other samples
+
+*/
+
+const (
+	defaultTmpfsSize = "64m"
+)
+
+var seccompOnce sync.Once
+var seccompProfile string //Contents of the seccomp profile set in the malscan config, docker expects the profile itself not a path
+
+//stageFile - Responsible for creating a per scan directory holding only the file to be scanned
+//the file is hard linked into the directory when possible and copied otherwise (or always when the plugin can write to it)
+//the caller is responsible for removing the returned directory
+func stageFile(fileOnDisk string, writable bool) (dir string, err error) {
+
+	scratch := config.Values.Sandbox.Scratch
+	if scratch == "" {
+		scratch = utils.GetScratchDir()
+	}
+
+	dir, err = ioutil.TempDir(scratch, "scan-")
+	if err != nil {
+		return "", errors.Wrap(err, "error while creating scan directory")
+	}
+
+	//Containers do not always run as the user that owns the scratch directory
+	if err = os.Chmod(dir, 0755); err != nil {
+		os.RemoveAll(dir)
+		return "", errors.Wrap(err, "error while setting permissions on scan directory")
+	}
+
+	staged := filepath.Join(dir, filepath.Base(fileOnDisk))
+
+	if writable || os.Link(fileOnDisk, staged) != nil {
+		if err = copyFile(fileOnDisk, staged); err != nil {
+			os.RemoveAll(dir)
+			return "", errors.Wrap(err, "error while copying file into scan directory")
+		}
+	}
+
+	return dir, nil
+}
+
+//copyFile - Copies src to dst, dst is created readable by everyone
+func copyFile(src string, dst string) error {
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+//hardenHostConfig - Responsible for applying the hardened profile to a containers host config
+//by default the container has no network, no capabilities, cannot gain privileges, has a read only root filesystem
+//with a tmpfs at /tmp and a read only mount of the scan directory when there is one, each of these can be opted out of per plugin
+func hardenHostConfig(hostConfig *container.HostConfig, scanDir string, options RunOptions) {
+
+	if scanDir != "" {
+		hostConfig.Mounts = append(hostConfig.Mounts, mount.Mount{
+			Type:     mount.TypeBind,
+			Source:   scanDir,
+			Target:   "/malware",
+			ReadOnly: !options.WritableSample,
+		})
+	}
+
+	if !options.AllowNetwork {
+		hostConfig.NetworkMode = "none"
+	}
+
+	if !options.KeepCapabilities {
+		hostConfig.CapDrop = []string{"ALL"}
+	}
+
+	if !options.AllowNewPrivileges {
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "no-new-privileges")
+	}
+
+	if !options.WritableRootfs {
+		tmpfsSize := config.Values.Sandbox.TmpfsSize
+		if tmpfsSize == "" {
+			tmpfsSize = defaultTmpfsSize
+		}
+		hostConfig.ReadonlyRootfs = true
+		hostConfig.Tmpfs = map[string]string{"/tmp": "rw,noexec,nosuid,size=" + tmpfsSize}
+	}
+
+	if !options.DisableSeccomp {
+		if profile := loadSeccompProfile(); profile != "" {
+			hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "seccomp="+profile)
+		}
+	}
+}
+
+//loadSeccompProfile - Reads the seccomp profile set in the malscan config once, an empty string leaves dockers default profile in place
+func loadSeccompProfile() string {
+
+	seccompOnce.Do(func() {
+
+		if config.Values.Sandbox.SeccompProfile == "" {
+			return
+		}
+
+		data, err := ioutil.ReadFile(config.Values.Sandbox.SeccompProfile)
+		if err != nil {
+			log.WithFields(log.Fields{"err": err}).Fatalf("reading seccomp profile:%s", config.Values.Sandbox.SeccompProfile)
+		}
+
+		seccompProfile = string(data)
+	})
+
+	return seccompProfile
+}
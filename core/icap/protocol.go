@@ -0,0 +1,230 @@
+package icap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains functions related to reading and writing ICAP (RFC 3507) messages
+
+*/
+
+//request - An ICAP request with its encapsulated http headers, the body is read separately as it may be previewed
+type request struct {
+	Method    string
+	URI       string
+	Proto     string
+	Header    textproto.MIMEHeader
+	ReqHeader []byte //Raw encapsulated http request headers
+	ResHeader []byte //Raw encapsulated http response headers
+	HasBody   bool   //Whether a req-body or res-body follows the headers
+}
+
+//entity - A single entry from the Encapsulated header
+type entity struct {
+	name   string
+	offset int
+}
+
+//readRequest - Reads an ICAP request line, its headers and any encapsulated http headers
+func readRequest(br *bufio.Reader) (*request, error) {
+
+	tp := textproto.NewReader(br)
+
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) != 3 || !strings.HasPrefix(parts[2], "ICAP/") {
+		return nil, errors.New("malformed ICAP request line: " + line)
+	}
+
+	req := &request{Method: parts[0], URI: parts[1], Proto: parts[2]}
+
+	req.Header, err = tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, errors.Wrap(err, "error while reading ICAP headers")
+	}
+
+	entities, err := parseEncapsulated(req.Header.Get("Encapsulated"))
+	if err != nil {
+		return nil, err
+	}
+
+	//Every entity but the last is a block of http headers whose length is the gap to the next offset
+	for i, e := range entities {
+
+		if i == len(entities)-1 {
+			req.HasBody = e.name == "req-body" || e.name == "res-body"
+			break
+		}
+
+		length := entities[i+1].offset - e.offset
+		if length < 0 {
+			return nil, errors.New("Encapsulated offsets are not in order")
+		}
+
+		buf := make([]byte, length)
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return nil, errors.Wrap(err, "error while reading encapsulated "+e.name)
+		}
+
+		switch e.name {
+		case "req-hdr":
+			req.ReqHeader = buf
+		case "res-hdr":
+			req.ResHeader = buf
+		}
+	}
+
+	return req, nil
+}
+
+//parseEncapsulated - Parses an Encapsulated header such as "req-hdr=0, res-hdr=137, res-body=296"
+func parseEncapsulated(header string) ([]entity, error) {
+
+	var entities []entity
+
+	if strings.TrimSpace(header) == "" {
+		return entities, nil
+	}
+
+	for _, field := range strings.Split(header, ",") {
+
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.New("malformed Encapsulated header: " + header)
+		}
+
+		offset, err := strconv.Atoi(kv[1])
+		if err != nil {
+			return nil, errors.Wrap(err, "malformed Encapsulated header: "+header)
+		}
+
+		entities = append(entities, entity{name: kv[0], offset: offset})
+	}
+
+	return entities, nil
+}
+
+//readChunks - Copies a chunked body to w until the zero length chunk, ieof is true when the
+//zero length chunk carried the ieof extension meaning the client has no more data after a preview
+func readChunks(br *bufio.Reader, w io.Writer) (ieof bool, err error) {
+
+	tp := textproto.NewReader(br)
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return false, errors.Wrap(err, "error while reading chunk size")
+		}
+
+		sizeField := line
+		extension := ""
+		if i := strings.Index(line, ";"); i >= 0 {
+			sizeField, extension = line[:i], strings.TrimSpace(line[i+1:])
+		}
+
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+		if err != nil || size < 0 {
+			return false, errors.New("malformed chunk size: " + line)
+		}
+
+		if size == 0 {
+			//Skip any trailers up to the blank line that ends the body
+			for {
+				trailer, err := tp.ReadLine()
+				if err != nil {
+					return false, errors.Wrap(err, "error while reading chunk trailer")
+				}
+				if trailer == "" {
+					break
+				}
+			}
+			return extension == "ieof", nil
+		}
+
+		if _, err := io.CopyN(w, br, size); err != nil {
+			return false, errors.Wrap(err, "error while reading chunk")
+		}
+
+		//Each chunk is followed by a CRLF
+		if _, err := tp.ReadLine(); err != nil {
+			return false, errors.Wrap(err, "error while reading chunk terminator")
+		}
+	}
+}
+
+//errBodyTooLarge - Returned by a limitWriter once more than its limit has been written to it
+var errBodyTooLarge = errors.New("body is larger than max_body_size")
+
+//errClose - Returned by a handler that answered the request without reading the rest of its body,
+//the answer is sent and the connection closed as the next request can not be found
+var errClose = errors.New("connection closed after answering")
+
+//limitWriter - Writes to w until more than n bytes would have been written, then fails with errBodyTooLarge
+type limitWriter struct {
+	w io.Writer
+	n int64
+}
+
+//Write - Writes p to the underlying writer unless that would take it over the limit
+func (lw *limitWriter) Write(p []byte) (int, error) {
+
+	if int64(len(p)) > lw.n {
+		return 0, errBodyTooLarge
+	}
+
+	n, err := lw.w.Write(p)
+	lw.n -= int64(n)
+
+	return n, err
+}
+
+//writeChunks - Writes the contents of r as a chunked body ending with the zero length chunk
+func writeChunks(bw *bufio.Writer, r io.Reader) error {
+
+	buf := make([]byte, 32*1024)
+
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			fmt.Fprintf(bw, "%x\r\n", n)
+			bw.Write(buf[:n])
+			bw.WriteString("\r\n")
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := bw.WriteString("0\r\n\r\n")
+	return err
+}
+
+//writeStatus - Writes an ICAP status line and headers, headers are written in the order given
+func writeStatus(bw *bufio.Writer, code int, reason string, headers [][2]string) {
+
+	fmt.Fprintf(bw, "ICAP/1.0 %d %s\r\n", code, reason)
+
+	for _, header := range headers {
+		fmt.Fprintf(bw, "%s: %s\r\n", header[0], header[1])
+	}
+
+	bw.WriteString("\r\n")
+}
@@ -0,0 +1,568 @@
+package icap
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"malscan/config"
+	"malscan/core/scan"
+	"malscan/core/utils"
+	pconfig "malscan/plugins"
+	"malscan/structs"
+
+	units "github.com/docker/go-units"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains the ICAP server used to scan content passing through proxies and gateways
+
+*/
+
+const (
+	defaultListen      = "127.0.0.1:1344"
+	defaultService     = "malscan"
+	defaultMaxBodySize = 100 * 1024 * 1024
+	defaultPreview     = 4096
+	defaultFilename    = "sample"
+	readTimeout        = 5 * time.Minute
+	maxFilenameSize    = 200 //Bytes kept of the sample name, under the 255 most filesystems allow
+)
+
+//defaultBlockPage - Block page returned in place of infected content when no block_page is configured
+const defaultBlockPage = `<!DOCTYPE html>
+<html>
+<head><title>Blocked by malscan</title></head>
+<body>
+<h1>This content has been blocked</h1>
+<p>{{.URL}} was found to contain malware: {{.Threat}}</p>
+</body>
+</html>
+`
+
+//Server - ICAP server in front of a scan pool
+type Server struct {
+	pool        *scan.Pool
+	service     string
+	istag       string
+	maxBodySize int64
+	block       map[string]bool
+	blockPage   *template.Template
+}
+
+//blockPageData - Values the block page template can use
+type blockPageData struct {
+	Threat string
+	URL    string
+}
+
+//Serve - Responsible for starting the ICAP server, content is scanned on a pool using the mode-3 settings from the malscan config.
+//On SIGINT or SIGTERM the server stops accepting connections and running scans are drained
+func Serve() error {
+
+	plugins := pconfig.PluginConfig{}
+	plugins = plugins.Load()
+	plugins.CollectVersions()
+
+	defer plugins.StartDaemonLease()()
+	defer plugins.StartStalenessMonitor()()
+	defer plugins.StartContainerReaper()()
+	defer plugins.StartUpdater()()
+
+	pool := scan.NewPool(scan.Settings{
+		Files:      config.Values.Env.MaxFileProc,
+		Plugins:    config.Values.Env.MaxPluginProc,
+		QueueDepth: config.Values.Env.QueueDepth,
+	}, plugins)
+
+	server, err := NewServer(pool)
+	if err != nil {
+		return err
+	}
+
+	listen := config.Values.ICAP.Listen
+	if listen == "" {
+		listen = defaultListen
+	}
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return errors.Wrap(err, "error while listening on: "+listen)
+	}
+
+	log.Infof("malscan icap listening on:%s:service:%s", listen, server.service)
+
+	closing := make(chan struct{})  //Closed when the listener is closed on purpose
+	shutdown := make(chan struct{}) //Closed once the pool has been drained
+	go func() {
+		sig := <-scan.Signals()
+		log.Infof("received:%s:stopping malscan icap", sig)
+		close(closing)
+		listener.Close()
+
+		//Clients waiting on a body that was not scanned are told the service is unavailable so they can retry elsewhere
+		requeue := pool.Shutdown(scan.DrainTimeout())
+		log.Infof("stopped with:%d:bodies unscanned", len(requeue))
+		close(shutdown)
+	}()
+
+	err = server.Serve(listener)
+
+	select {
+	case <-closing:
+		<-shutdown
+		return nil
+	default:
+		return err
+	}
+}
+
+//NewServer - Responsible for creating an ICAP server for the pool passed in
+func NewServer(pool *scan.Pool) (*Server, error) {
+
+	server := &Server{
+		pool:        pool,
+		service:     config.Values.ICAP.Service,
+		maxBodySize: defaultMaxBodySize,
+		block:       make(map[string]bool),
+	}
+
+	if server.service == "" {
+		server.service = defaultService
+	}
+
+	//The ISTag changes whenever the server starts so caches do not keep responses from before a restart
+	server.istag = fmt.Sprintf("\"%s-%d\"", server.service, time.Now().Unix())
+
+	if config.Values.ICAP.MaxBodySize != "" {
+		size, err := units.RAMInBytes(config.Values.ICAP.MaxBodySize)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid max_body_size")
+		}
+		server.maxBodySize = size
+	}
+
+	block := config.Values.ICAP.Block
+	if len(block) == 0 {
+		block = []string{structs.VerdictMalicious}
+	}
+	for _, verdict := range block {
+		server.block[verdict] = true
+	}
+
+	page := defaultBlockPage
+	if config.Values.ICAP.BlockPage != "" {
+		data, err := ioutil.ReadFile(config.Values.ICAP.BlockPage)
+		if err != nil {
+			return nil, errors.Wrap(err, "error while reading block_page")
+		}
+		page = string(data)
+	}
+
+	tmpl, err := template.New("block").Parse(page)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid block_page")
+	}
+	server.blockPage = tmpl
+
+	return server, nil
+}
+
+//Serve - Accepts ICAP connections on the listener until it is closed
+func (server *Server) Serve(listener net.Listener) error {
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go server.handleConn(conn)
+	}
+}
+
+//handleConn - Handles every request on a connection, ICAP connections are persistent so requests are read until the client closes it
+func (server *Server) handleConn(conn net.Conn) {
+
+	defer conn.Close()
+
+	br := bufio.NewReader(conn)
+	bw := bufio.NewWriter(conn)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+
+		req, err := readRequest(br)
+		if err != nil {
+			if _, ok := err.(net.Error); !ok && errors.Cause(err) != io.EOF {
+				log.WithFields(log.Fields{"err": err}).Errorf("reading icap request from:%s", conn.RemoteAddr())
+				writeStatus(bw, 400, "Bad Request", server.headers())
+				bw.Flush()
+			}
+			return
+		}
+
+		if err := server.handle(req, br, bw); err != nil {
+			if err == errClose {
+				bw.Flush()
+				return
+			}
+			log.WithFields(log.Fields{"err": err}).Errorf("handling icap %s from:%s", req.Method, conn.RemoteAddr())
+			return
+		}
+
+		if err := bw.Flush(); err != nil {
+			return
+		}
+
+		if strings.EqualFold(req.Header.Get("Connection"), "close") {
+			return
+		}
+	}
+}
+
+//handle - Routes a request to its handler
+func (server *Server) handle(req *request, br *bufio.Reader, bw *bufio.Writer) error {
+
+	switch req.Method {
+	case "OPTIONS":
+		server.options(req, bw)
+		return nil
+	case "REQMOD", "RESPMOD":
+		return server.modify(req, br, bw)
+	}
+
+	//The body still has to be read so the next request on the connection can be parsed
+	if req.HasBody {
+		if _, err := readChunks(br, ioutil.Discard); err != nil {
+			return err
+		}
+	}
+
+	writeStatus(bw, 501, "Method Not Implemented", server.headers())
+	return nil
+}
+
+//options - Advertises the method of the service requested, the preview size and that 204 responses are supported
+func (server *Server) options(req *request, bw *bufio.Writer) {
+
+	method := "RESPMOD"
+	if strings.HasSuffix(strings.ToLower(strings.TrimSuffix(req.URI, "/")), "reqmod") {
+		method = "REQMOD"
+	}
+
+	headers := append(server.headers(),
+		[2]string{"Methods", method},
+		[2]string{"Service", "malscan icap"},
+		[2]string{"Allow", "204"},
+		[2]string{"Preview", strconv.Itoa(defaultPreview)},
+		[2]string{"Transfer-Preview", "*"},
+		[2]string{"Options-TTL", "3600"},
+		[2]string{"Encapsulated", "null-body=0"},
+	)
+
+	writeStatus(bw, 200, "OK", headers)
+}
+
+//modify - Handles REQMOD and RESPMOD, the encapsulated body is scanned and either passed back unmodified or replaced with a block page
+func (server *Server) modify(req *request, br *bufio.Reader, bw *bufio.Writer) error {
+
+	allow204 := false
+	for _, value := range strings.Split(req.Header.Get("Allow"), ",") {
+		if strings.TrimSpace(value) == "204" {
+			allow204 = true
+		}
+	}
+
+	if !req.HasBody {
+		return server.unmodified(req, bw, allow204, nil)
+	}
+
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+
+	//Each body gets its own directory so the filename from the url can be kept in the report
+	dir := filepath.Join(utils.GetUploadsDir(), "icap-"+id)
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	url := requestURL(req)
+	samplePath := filepath.Join(dir, filenameFromURL(url))
+
+	f, err := os.OpenFile(samplePath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		//A name the filesystem still refuses should not cost the client its answer
+		log.WithFields(log.Fields{"err": err}).Warnf("creating icap sample:%s:using:%s", samplePath, defaultFilename)
+		samplePath = filepath.Join(dir, defaultFilename)
+		f, err = os.OpenFile(samplePath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	}
+	if err != nil {
+		return server.failed(bw, errors.Wrap(err, "error while creating icap sample"))
+	}
+
+	//Bodies are only spooled up to max_body_size so a client can not fill the uploads disk
+	w := &limitWriter{w: f, n: server.maxBodySize}
+
+	ieof, err := readChunks(br, w)
+
+	//After a preview the client waits for a 100 Continue before sending the rest of the body,
+	//unless the preview ended with ieof in which case there is nothing more to send
+	if err == nil && req.Header.Get("Preview") != "" && !ieof {
+		writeStatus(bw, 100, "Continue", nil)
+		if err = bw.Flush(); err == nil {
+			_, err = readChunks(br, w)
+		}
+	}
+
+	f.Close()
+
+	if errors.Cause(err) == errBodyTooLarge {
+		return server.tooLarge(req, bw, allow204, url)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	done := make(chan structs.FullFileReport, 1)
+	queued := server.pool.TrySubmit(samplePath, func(filename string, report structs.FullFileReport) {
+		done <- report
+	})
+	if !queued {
+		return server.unavailable(bw)
+	}
+
+	var report structs.FullFileReport
+	select {
+	case report = <-done:
+	case <-server.pool.Stopped():
+		//The pool shut down before the body was scanned, unless the scan finished just before it did
+		select {
+		case report = <-done:
+		default:
+			return server.unavailable(bw)
+		}
+	}
+
+	if !server.block[report.File.Malware.Verdict] {
+		return server.unmodified(req, bw, allow204, &samplePath)
+	}
+
+	threat := threatName(report)
+
+	log.Warnf("icap blocked:%s:threat:%s:verdict:%s", url, threat, report.File.Malware.Verdict)
+
+	return server.blocked(bw, threat, url)
+}
+
+//tooLarge - Answers a request whose body is larger than max_body_size, the rest of the body is never read so the connection
+//is closed once answered. The body was not kept so it can only be passed through with a 204, clients that do not allow
+//204 are told the body could not be handled
+func (server *Server) tooLarge(req *request, bw *bufio.Writer, allow204 bool, url string) error {
+
+	log.Warnf("icap body:%s:is larger than max_body_size, passing it through unscanned", url)
+
+	if allow204 {
+		server.unmodified(req, bw, true, nil)
+	} else {
+		writeStatus(bw, 500, "Server Error", server.headers())
+	}
+
+	return errClose
+}
+
+//failed - Tells the client the body could not be handled, the rest of the body is never read so the connection is closed once answered
+func (server *Server) failed(bw *bufio.Writer, err error) error {
+
+	log.WithFields(log.Fields{"err": err}).Error("handling icap request")
+
+	writeStatus(bw, 500, "Server Error", server.headers())
+	return errClose
+}
+
+//unavailable - Tells the client the body could not be scanned because the scan queue is full or malscan is shutting down
+func (server *Server) unavailable(bw *bufio.Writer) error {
+
+	writeStatus(bw, 503, "Service Unavailable", server.headers())
+	return nil
+}
+
+//unmodified - Tells the client to use the original message, with a 204 when the client allows it or by echoing the message back
+func (server *Server) unmodified(req *request, bw *bufio.Writer, allow204 bool, body *string) error {
+
+	if allow204 {
+		writeStatus(bw, 204, "No Content", append(server.headers(), [2]string{"Encapsulated", "null-body=0"}))
+		return nil
+	}
+
+	//A REQMOD response carries the request and a RESPMOD response carries the response
+	header, headerName, bodyName := req.ReqHeader, "req-hdr", "req-body"
+	if req.Method == "RESPMOD" {
+		header, headerName, bodyName = req.ResHeader, "res-hdr", "res-body"
+	}
+
+	var encapsulated []string
+	if len(header) > 0 {
+		encapsulated = append(encapsulated, headerName+"=0")
+	}
+	if body != nil {
+		encapsulated = append(encapsulated, fmt.Sprintf("%s=%d", bodyName, len(header)))
+	} else {
+		encapsulated = append(encapsulated, fmt.Sprintf("null-body=%d", len(header)))
+	}
+
+	writeStatus(bw, 200, "OK", append(server.headers(), [2]string{"Encapsulated", strings.Join(encapsulated, ", ")}))
+	bw.Write(header)
+
+	if body == nil {
+		return nil
+	}
+
+	f, err := os.Open(*body)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return writeChunks(bw, f)
+}
+
+//blocked - Replaces the message with a 403 block page, the threat is reported in the X-Infection-Found and X-Virus-ID headers
+func (server *Server) blocked(bw *bufio.Writer, threat string, url string) error {
+
+	page := new(bytes.Buffer)
+	if err := server.blockPage.Execute(page, blockPageData{Threat: threat, URL: url}); err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("rendering block page")
+		page.Reset()
+		page.WriteString("blocked by malscan: " + threat)
+	}
+
+	header := fmt.Sprintf("HTTP/1.1 403 Forbidden\r\nContent-Type: text/html; charset=utf-8\r\nContent-Length: %d\r\nCache-Control: no-store\r\nConnection: close\r\n\r\n", page.Len())
+
+	headers := append(server.headers(),
+		[2]string{"X-Infection-Found", fmt.Sprintf("Type=0; Resolution=2; Threat=%s;", threat)},
+		[2]string{"X-Virus-ID", threat},
+		[2]string{"Encapsulated", fmt.Sprintf("res-hdr=0, res-body=%d", len(header))},
+	)
+
+	writeStatus(bw, 200, "OK", headers)
+	bw.WriteString(header)
+
+	return writeChunks(bw, page)
+}
+
+//headers - Headers sent with every response
+func (server *Server) headers() [][2]string {
+
+	return [][2]string{
+		{"ISTag", server.istag},
+		{"Date", time.Now().UTC().Format(http.TimeFormat)},
+		{"Server", "malscan"},
+	}
+}
+
+//requestURL - Returns the url of the http request encapsulated in the ICAP request, or "" if there is no request
+func requestURL(req *request) string {
+
+	if len(req.ReqHeader) == 0 {
+		return ""
+	}
+
+	httpReq, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(req.ReqHeader)))
+	if err != nil {
+		return ""
+	}
+
+	if httpReq.URL.Host == "" {
+		httpReq.URL.Host = httpReq.Host
+	}
+
+	return httpReq.URL.String()
+}
+
+//filenameFromURL - Names the sample after the last element of the url path so reports show something meaningful
+func filenameFromURL(rawURL string) string {
+
+	parsed, err := neturl.Parse(rawURL)
+	if err != nil {
+		return defaultFilename
+	}
+
+	//Control characters such as an escaped NUL and backslashes are not kept in the name
+	name := strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '\\' || r == utf8.RuneError {
+			return '_'
+		}
+		return r
+	}, path.Base(parsed.Path))
+
+	if name == "/" || name == "." || name == ".." {
+		return defaultFilename
+	}
+
+	//Names are cut to fit the filesystem, keeping the extension
+	if len(name) > maxFilenameSize {
+		ext := path.Ext(name)
+		if len(ext) > maxFilenameSize/4 {
+			ext = ""
+		}
+		base := name[:maxFilenameSize-len(ext)]
+		for !utf8.ValidString(base) {
+			base = base[:len(base)-1]
+		}
+		name = base + ext
+	}
+
+	return name
+}
+
+//threatName - Returns the name of the first detection in a report, line breaks are removed as the name is sent in a header
+func threatName(report structs.FullFileReport) string {
+
+	for _, result := range report.File.Malware.Results {
+		if result = strings.TrimSpace(strings.NewReplacer("\r", " ", "\n", " ").Replace(result)); result != "" {
+			return result
+		}
+	}
+
+	if len(report.File.Malware.Analyzers.Names) > 0 {
+		return report.File.Malware.Analyzers.Names[0]
+	}
+
+	return "unknown"
+}
+
+//newID - Generates a random id for a request body
+func newID() (string, error) {
+
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,345 @@
+package icap
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"malscan/core/scan"
+	pconfig "malscan/plugins"
+	"malscan/structs"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Tests the ICAP server end to end over an in memory connection, bodies are scanned on a pool with no plugins
+
+*/
+
+const (
+	testReqHeader = "GET http://example.com/files/report.txt HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	testResHeader = "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\n"
+)
+
+//response - An ICAP response read by a test client
+type response struct {
+	Status    int
+	Header    textproto.MIMEHeader
+	ResHeader string //Encapsulated http response headers
+	Body      []byte //Encapsulated http response body, nil when there is none
+}
+
+//testServer - Returns a server scanning on a pool with no plugins, the pool is shut down when the test ends
+func testServer(t *testing.T) *Server {
+
+	pool := scan.NewPool(scan.Settings{Files: 1}, pconfig.PluginConfig{})
+	t.Cleanup(func() { pool.Shutdown(time.Second) })
+
+	server, err := NewServer(pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return server
+}
+
+//connect - Hands one end of an in memory connection to the server and returns the other end
+func connect(t *testing.T, server *Server) (net.Conn, *bufio.Reader) {
+
+	client, conn := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+
+	client.SetDeadline(time.Now().Add(30 * time.Second))
+
+	go server.handleConn(conn)
+
+	return client, bufio.NewReader(client)
+}
+
+//send - Writes to the connection without waiting for the server to read it, the server may answer before reading everything
+func send(conn net.Conn, data string) {
+
+	go conn.Write([]byte(data))
+}
+
+//respmod - Returns a RESPMOD request carrying the body as the chunks passed in, extra holds ICAP headers such as Allow and Preview
+func respmod(extra string, chunks string) string {
+
+	return fmt.Sprintf("RESPMOD icap://127.0.0.1/malscan ICAP/1.0\r\nHost: 127.0.0.1\r\n%sEncapsulated: req-hdr=0, res-hdr=%d, res-body=%d\r\n\r\n%s%s%s",
+		extra, len(testReqHeader), len(testReqHeader)+len(testResHeader), testReqHeader, testResHeader, chunks)
+}
+
+//chunk - Encodes data as a single chunk
+func chunk(data string) string {
+
+	return fmt.Sprintf("%x\r\n%s\r\n", len(data), data)
+}
+
+//readResponse - Reads an ICAP response along with the encapsulated response headers and body
+func readResponse(t *testing.T, br *bufio.Reader) response {
+
+	tp := textproto.NewReader(br)
+
+	line, err := tp.ReadLine()
+	if err != nil {
+		t.Fatalf("reading status line: %v", err)
+	}
+
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 || parts[0] != "ICAP/1.0" {
+		t.Fatalf("malformed status line %q", line)
+	}
+
+	var resp response
+	if resp.Status, err = strconv.Atoi(parts[1]); err != nil {
+		t.Fatalf("malformed status line %q", line)
+	}
+
+	if resp.Header, err = tp.ReadMIMEHeader(); err != nil {
+		t.Fatalf("reading headers: %v", err)
+	}
+
+	entities, err := parseEncapsulated(resp.Header.Get("Encapsulated"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offset := 0
+	for _, e := range entities {
+		buf := make([]byte, e.offset-offset)
+		if _, err := io.ReadFull(br, buf); err != nil {
+			t.Fatalf("reading encapsulated headers: %v", err)
+		}
+		resp.ResHeader += string(buf)
+		offset = e.offset
+
+		if e.name == "req-body" || e.name == "res-body" {
+			body := new(bytes.Buffer)
+			if _, err := readChunks(br, body); err != nil {
+				t.Fatalf("reading encapsulated body: %v", err)
+			}
+			resp.Body = body.Bytes()
+		}
+	}
+
+	return resp
+}
+
+//expectClosed - Fails the test unless the server closed the connection
+func expectClosed(t *testing.T, br *bufio.Reader) {
+
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Errorf("connection was left open, read returned %v", err)
+	}
+}
+
+func TestOptions(t *testing.T) {
+
+	tests := []struct {
+		uri    string
+		method string
+	}{
+		{"icap://127.0.0.1/malscan", "RESPMOD"},
+		{"icap://127.0.0.1/malscan/reqmod", "REQMOD"},
+	}
+
+	server := testServer(t)
+	conn, br := connect(t, server)
+
+	//Both requests go over the same connection as ICAP connections are persistent
+	for _, test := range tests {
+		send(conn, "OPTIONS "+test.uri+" ICAP/1.0\r\nHost: 127.0.0.1\r\nEncapsulated: null-body=0\r\n\r\n")
+
+		resp := readResponse(t, br)
+
+		if resp.Status != 200 {
+			t.Fatalf("OPTIONS %s = %d, want 200", test.uri, resp.Status)
+		}
+		if got := resp.Header.Get("Methods"); got != test.method {
+			t.Errorf("OPTIONS %s Methods = %q, want %q", test.uri, got, test.method)
+		}
+		if got := resp.Header.Get("Allow"); got != "204" {
+			t.Errorf("OPTIONS %s Allow = %q, want 204", test.uri, got)
+		}
+		if got := resp.Header.Get("Preview"); got != strconv.Itoa(defaultPreview) {
+			t.Errorf("OPTIONS %s Preview = %q, want %d", test.uri, got, defaultPreview)
+		}
+		if resp.Header.Get("ISTag") != server.istag {
+			t.Errorf("OPTIONS %s ISTag = %q, want %q", test.uri, resp.Header.Get("ISTag"), server.istag)
+		}
+	}
+}
+
+func TestModify(t *testing.T) {
+
+	body := "first part of the body, " + strings.Repeat("x", 5000)
+
+	tests := []struct {
+		name     string
+		allow204 bool
+		block    bool
+	}{
+		{"204", true, false},
+		{"echoed", false, false},
+		{"blocked", true, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			server := testServer(t)
+			if test.block {
+				//A pool with no plugins can not find malware so every verdict is blocked
+				for _, verdict := range []string{structs.VerdictMalicious, structs.VerdictSuspicious, structs.VerdictClean, structs.VerdictUnknown} {
+					server.block[verdict] = true
+				}
+			}
+
+			conn, br := connect(t, server)
+
+			extra := ""
+			if test.allow204 {
+				extra = "Allow: 204\r\n"
+			}
+			send(conn, respmod(extra, chunk(body[:24])+chunk(body[24:])+"0\r\n\r\n"))
+
+			resp := readResponse(t, br)
+
+			switch {
+			case test.block:
+				if resp.Status != 200 || !strings.HasPrefix(resp.ResHeader, "HTTP/1.1 403") || resp.Header.Get("X-Infection-Found") == "" {
+					t.Fatalf("RESPMOD = %d %q, want a 403 block page", resp.Status, resp.ResHeader)
+				}
+			case test.allow204:
+				if resp.Status != 204 || resp.Body != nil {
+					t.Fatalf("RESPMOD = %d with %d body bytes, want 204", resp.Status, len(resp.Body))
+				}
+			default:
+				if resp.Status != 200 || resp.ResHeader != testResHeader || string(resp.Body) != body {
+					t.Fatalf("RESPMOD = %d %q with %d body bytes, want the response echoed", resp.Status, resp.ResHeader, len(resp.Body))
+				}
+			}
+		})
+	}
+}
+
+func TestPreview(t *testing.T) {
+
+	server := testServer(t)
+	conn, br := connect(t, server)
+
+	//A preview ending in ieof holds the whole body so it is answered without a 100 Continue
+	send(conn, respmod("Allow: 204\r\nPreview: 10\r\n", chunk("short")+"0; ieof\r\n\r\n"))
+
+	if resp := readResponse(t, br); resp.Status != 204 {
+		t.Fatalf("RESPMOD with an ieof preview = %d, want 204", resp.Status)
+	}
+
+	//Otherwise the server asks for the rest of the body, which is echoed back in full
+	send(conn, respmod("Preview: 10\r\n", chunk("0123456789")+"0\r\n\r\n"))
+
+	if resp := readResponse(t, br); resp.Status != 100 {
+		t.Fatalf("RESPMOD with a preview = %d, want 100 Continue", resp.Status)
+	}
+
+	send(conn, chunk("rest of the body")+"0\r\n\r\n")
+
+	resp := readResponse(t, br)
+	if resp.Status != 200 || string(resp.Body) != "0123456789rest of the body" {
+		t.Fatalf("RESPMOD after 100 Continue = %d with body %q, want the whole body echoed", resp.Status, resp.Body)
+	}
+}
+
+func TestMaxBodySize(t *testing.T) {
+
+	tests := []struct {
+		allow204 bool
+		want     int
+	}{
+		{true, 204},
+		{false, 500},
+	}
+
+	for _, test := range tests {
+
+		server := testServer(t)
+		server.maxBodySize = 16
+
+		conn, br := connect(t, server)
+
+		extra := ""
+		if test.allow204 {
+			extra = "Allow: 204\r\n"
+		}
+		send(conn, respmod(extra, chunk(strings.Repeat("x", 64))+"0\r\n\r\n"))
+
+		if resp := readResponse(t, br); resp.Status != test.want {
+			t.Errorf("RESPMOD over max_body_size with allow 204 %v = %d, want %d", test.allow204, resp.Status, test.want)
+		}
+
+		//The rest of the body is never read so the connection can not be used again
+		expectClosed(t, br)
+	}
+}
+
+func TestSampleName(t *testing.T) {
+
+	server := testServer(t)
+	conn, br := connect(t, server)
+
+	//Names the filesystem refuses are still scanned and answered
+	for _, name := range []string{strings.Repeat("a", 300) + ".exe", "evil%00.exe", "%ff%fe.bin"} {
+		reqHeader := "GET http://example.com/" + name + " HTTP/1.1\r\nHost: example.com\r\n\r\n"
+
+		send(conn, fmt.Sprintf("RESPMOD icap://127.0.0.1/malscan ICAP/1.0\r\nHost: 127.0.0.1\r\nAllow: 204\r\nEncapsulated: req-hdr=0, res-hdr=%d, res-body=%d\r\n\r\n%s%s%s0\r\n\r\n",
+			len(reqHeader), len(reqHeader)+len(testResHeader), reqHeader, testResHeader, chunk("body")))
+
+		if resp := readResponse(t, br); resp.Status != 204 {
+			t.Fatalf("RESPMOD for %q = %d, want 204", name, resp.Status)
+		}
+	}
+}
+
+func TestFilenameFromURL(t *testing.T) {
+
+	long := strings.Repeat("a", 300)
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://example.com/files/report.pdf", "report.pdf"},
+		{"http://example.com/files/report.pdf?download=1", "report.pdf"},
+		{"http://example.com/", defaultFilename},
+		{"http://example.com", defaultFilename},
+		{"", defaultFilename},
+		{"http://example.com/evil%00.exe", "evil_.exe"},
+		{"http://example.com/a%0d%0ab", "a__b"},
+		{"http://example.com/dir%5c..%5cevil", "dir_.._evil"},
+		{"http://example.com/%ff.bin", "_.bin"},
+		{"http://example.com/" + long + ".exe", long[:maxFilenameSize-4] + ".exe"},
+		{"http://example.com/" + long, long[:maxFilenameSize]},
+		{"http://example.com/" + strings.Repeat("é", 150), strings.Repeat("é", maxFilenameSize/2)},
+		{"http://example.com/a" + strings.Repeat("é", 150), "a" + strings.Repeat("é", (maxFilenameSize-1)/2)},
+	}
+
+	for _, test := range tests {
+		got := filenameFromURL(test.url)
+		if got != test.want {
+			t.Errorf("filenameFromURL(%q) = %q, want %q", test.url, got, test.want)
+		}
+		if len(got) > maxFilenameSize || !utf8.ValidString(got) {
+			t.Errorf("filenameFromURL(%q) = %q, want at most %d bytes of valid utf-8", test.url, got, maxFilenameSize)
+		}
+	}
+}
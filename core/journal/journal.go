@@ -0,0 +1,269 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains the scan queue journal, an append only file recording each file as it moves through the scan queue
+so files that were queued or being scanned when malscan stopped can be scanned again on startup
+
+*/
+
+//States a file moves through in the journal
+const (
+	StateReceived   = "received"    //File was picked up and queued
+	StateInProgress = "in-progress" //A worker started scanning the file
+	StateDone       = "done"        //File was scanned and disposed of
+)
+
+//compactAfter - Lines of finished work the journal can hold before it is rewritten with only the pending files
+const compactAfter = 10000
+
+//Journal - Append only record of the scan queue
+type Journal struct {
+	mutex   sync.Mutex
+	path    string
+	f       *os.File
+	pending map[string]string //State of every file that is not done
+	order   []string          //Files in the order they were received, may hold files that are done
+	lines   int               //Lines in the journal file
+}
+
+//entry - A single line of the journal
+type entry struct {
+	Time  time.Time `json:"time"`
+	State string    `json:"state"`
+	File  string    `json:"file"`
+}
+
+//Open - Responsible for opening the journal at path and replaying it, the journal is compacted so it only holds the files still pending
+func Open(path string) (*Journal, error) {
+
+	j := &Journal{path: path, pending: make(map[string]string)}
+
+	if err := j.replay(); err != nil {
+		return nil, err
+	}
+
+	if err := j.compact(); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+//Pending - Returns the files that were received or in progress and never finished, in the order they were received
+func (j *Journal) Pending() []string {
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	//A file received again after it was done is in the order twice until the journal is compacted
+	seen := make(map[string]bool)
+
+	var pending []string
+	for _, filename := range j.order {
+		if _, ok := j.pending[filename]; ok && !seen[filename] {
+			seen[filename] = true
+			pending = append(pending, filename)
+		}
+	}
+
+	return pending
+}
+
+//Received - Records a file being queued, false is returned without recording anything if the file is already pending
+//so a file seen twice (by the watcher and on startup) is only queued once
+func (j *Journal) Received(filename string) (bool, error) {
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if _, ok := j.pending[filename]; ok {
+		return false, nil
+	}
+
+	return true, j.record(filename, StateReceived)
+}
+
+//Requeue - Records a pending file being queued again after a restart
+func (j *Journal) Requeue(filename string) error {
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.record(filename, StateReceived)
+}
+
+//InProgress - Records a worker starting to scan a file
+func (j *Journal) InProgress(filename string) error {
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.record(filename, StateInProgress)
+}
+
+//Done - Records a file having been scanned and disposed of
+func (j *Journal) Done(filename string) error {
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if err := j.record(filename, StateDone); err != nil {
+		return err
+	}
+
+	if j.lines-len(j.pending) > compactAfter {
+		return j.compact()
+	}
+
+	return nil
+}
+
+//Close - Closes the journal file
+func (j *Journal) Close() error {
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.f.Close()
+}
+
+//record - Appends an entry and syncs it to disk, must be called with the mutex held
+func (j *Journal) record(filename string, state string) error {
+
+	if err := j.write(j.f, entry{Time: time.Now(), State: state, File: filename}); err != nil {
+		return errors.Wrap(err, "error while writing to scan journal")
+	}
+
+	if err := j.f.Sync(); err != nil {
+		return errors.Wrap(err, "error while syncing scan journal")
+	}
+
+	j.apply(filename, state)
+	j.lines++
+
+	return nil
+}
+
+//apply - Updates the pending files with an entry
+func (j *Journal) apply(filename string, state string) {
+
+	if state == StateDone {
+		delete(j.pending, filename)
+		return
+	}
+
+	if _, ok := j.pending[filename]; !ok {
+		j.order = append(j.order, filename)
+	}
+
+	j.pending[filename] = state
+}
+
+//write - Writes a single entry as a line of json
+func (j *Journal) write(f *os.File, e entry) error {
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(data, '\n'))
+
+	return err
+}
+
+//replay - Reads the journal file into the pending files, a torn last line from a crash is skipped
+func (j *Journal) replay() error {
+
+	f, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "error while opening scan journal")
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		var e entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.File == "" {
+			log.Warnf("skipping unreadable scan journal line:%q", scanner.Text())
+			continue
+		}
+		j.apply(e.File, e.State)
+	}
+
+	return errors.Wrap(scanner.Err(), "error while reading scan journal")
+}
+
+//compact - Rewrites the journal with only the pending files and reopens it for appending, must be called with the mutex held
+func (j *Journal) compact() error {
+
+	if err := os.MkdirAll(filepath.Dir(j.path), 0755); err != nil {
+		return err
+	}
+
+	tmp := j.path + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrap(err, "error while compacting scan journal")
+	}
+
+	var order []string
+	for _, filename := range j.order {
+		state, ok := j.pending[filename]
+		if !ok {
+			continue
+		}
+		order = append(order, filename)
+		if err := j.write(f, entry{Time: time.Now(), State: state, File: filename}); err != nil {
+			f.Close()
+			return errors.Wrap(err, "error while compacting scan journal")
+		}
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return errors.Wrap(err, "error while compacting scan journal")
+	}
+	f.Close()
+
+	if err := os.Rename(tmp, j.path); err != nil {
+		return errors.Wrap(err, "error while compacting scan journal")
+	}
+
+	if j.f != nil {
+		j.f.Close()
+	}
+
+	j.f, err = os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrap(err, "error while opening scan journal")
+	}
+
+	j.order = order
+	j.lines = len(order)
+
+	log.Debugf("compacted scan journal:%d:pending files", len(order))
+
+	return nil
+}
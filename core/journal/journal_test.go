@@ -0,0 +1,182 @@
+package journal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Tests replaying, compacting and deduplicating the scan queue journal
+
+*/
+
+//openTemp - Opens a journal in a temporary directory, it is closed when the test finishes
+func openTemp(t *testing.T, path string) *Journal {
+
+	j, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { j.Close() })
+
+	return j
+}
+
+//lines - Returns the lines of the journal file, failing the test if any of them is not a journal entry
+func lines(t *testing.T, path string) []entry {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []entry
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		var e entry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("journal holds an unreadable line %q: %v", line, err)
+		}
+		entries = append(entries, e)
+	}
+
+	return entries
+}
+
+func TestReplayTornLine(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "journal")
+
+	journal := `{"time":"2021-06-01T00:00:00Z","state":"received","file":"a"}
+{"time":"2021-06-01T00:00:01Z","state":"received","file":"b"}
+{"time":"2021-06-01T00:00:02Z","state":"in-progress","file":"a"}
+{"time":"2021-06-01T00:00:03Z","state":"done","fi`
+
+	if err := ioutil.WriteFile(path, []byte(journal), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	j := openTemp(t, path)
+
+	if got, want := j.Pending(), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Pending = %v, want %v", got, want)
+	}
+
+	//The torn line is gone once the journal is compacted, so new entries start on a line of their own
+	if _, err := j.Received("c"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := lines(t, path)
+	if len(entries) != 3 {
+		t.Fatalf("journal holds %d lines, want 3", len(entries))
+	}
+	if entries[0].File != "a" || entries[0].State != StateInProgress {
+		t.Errorf("first line = %+v, want a in progress", entries[0])
+	}
+}
+
+func TestCompaction(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "journal")
+
+	j, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, filename := range []string{"a", "b", "c", "d"} {
+		if _, err := j.Received(filename); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, filename := range []string{"a", "b", "c"} {
+		if err := j.InProgress(filename); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, filename := range []string{"a", "c"} {
+		if err := j.Done(filename); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j.Close()
+
+	if got := len(lines(t, path)); got != 9 {
+		t.Fatalf("journal holds %d lines before compaction, want 9", got)
+	}
+
+	j = openTemp(t, path)
+
+	if got, want := j.Pending(), []string{"b", "d"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Pending = %v, want %v", got, want)
+	}
+
+	entries := lines(t, path)
+	want := []entry{{State: StateInProgress, File: "b"}, {State: StateReceived, File: "d"}}
+	if len(entries) != len(want) {
+		t.Fatalf("journal holds %d lines after compaction, want %d", len(entries), len(want))
+	}
+	for i := range want {
+		if entries[i].File != want[i].File || entries[i].State != want[i].State {
+			t.Errorf("line %d = %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+}
+
+func TestReceivedDedupe(t *testing.T) {
+
+	j := openTemp(t, filepath.Join(t.TempDir(), "journal"))
+
+	received := func(want bool) {
+		t.Helper()
+		got, err := j.Received("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("Received = %v, want %v", got, want)
+		}
+	}
+
+	received(true)
+	received(false)
+
+	if err := j.InProgress("a"); err != nil {
+		t.Fatal(err)
+	}
+	received(false)
+
+	if err := j.Done("a"); err != nil {
+		t.Fatal(err)
+	}
+	received(true)
+
+	if got, want := j.Pending(), []string{"a"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Pending = %v, want %v", got, want)
+	}
+}
+
+func TestOpenMissing(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "queue", "journal")
+
+	j := openTemp(t, path)
+
+	if pending := j.Pending(); len(pending) != 0 {
+		t.Fatalf("Pending = %v, want none", pending)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("journal was not created: %v", err)
+	}
+}
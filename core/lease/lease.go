@@ -0,0 +1,174 @@
+package lease
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains leases, files a running malscan holds to tell other malscans sharing its directories what it is doing.
+A lease is touched while it is held so one left behind by a malscan that died goes stale instead of being held forever
+
+*/
+
+const (
+	heartbeat  = 10 * time.Second //How often a held lease is touched
+	staleAfter = 3 * heartbeat    //A lease not touched for this long was left behind by a malscan that is no longer running
+	pollEvery  = time.Second      //How often Released checks a lease held by another malscan
+)
+
+//ErrHeld - Returned when a lease is already held by a running malscan
+var ErrHeld = errors.New("lease is held by another malscan")
+
+//ID - Random id of this malscan process. Unlike a pid it is never reused and means the same thing in every pid namespace
+var ID = newID()
+
+//newID - Generates the process id, the pid and start time are used if the random source fails
+func newID() string {
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.Itoa(os.Getpid()) + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+//Lease - A lease held by this malscan
+type Lease struct {
+	path     string
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+//Acquire - Responsible for taking the lease at path, ErrHeld is returned if a running malscan already holds it.
+//A stale lease is taken over. The lease is kept fresh until it is released
+func Acquire(path string) (*Lease, error) {
+
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return nil, errors.Wrap(err, "error while creating lease dir")
+	}
+
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = f.WriteString(ID + " " + strconv.Itoa(os.Getpid()) + "\n")
+			f.Close()
+			if err != nil {
+				os.Remove(path)
+				return nil, errors.Wrap(err, "error while writing lease: "+path)
+			}
+			break
+		}
+		if !os.IsExist(err) {
+			return nil, errors.Wrap(err, "error while creating lease: "+path)
+		}
+
+		if Held(path) {
+			return nil, ErrHeld
+		}
+
+		log.Warnf("taking over stale lease:%s", path)
+
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "error while removing stale lease: "+path)
+		}
+	}
+
+	l := &Lease{path: path, done: make(chan struct{})}
+
+	go l.keepFresh()
+
+	return l, nil
+}
+
+//keepFresh - Touches the lease until it is released
+func (l *Lease) keepFresh() {
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			if err := os.Chtimes(l.path, now, now); err != nil {
+				log.WithFields(log.Fields{"err": err}).Errorf("touching lease:%s", l.path)
+			}
+		case <-l.done:
+			return
+		}
+	}
+}
+
+//Release - Gives up the lease, it can be released more than once
+func (l *Lease) Release() {
+
+	l.doneOnce.Do(func() {
+		close(l.done)
+		if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+			log.WithFields(log.Fields{"err": err}).Errorf("removing lease:%s", l.path)
+		}
+	})
+}
+
+//Held - Tests whether a running malscan holds the lease at path
+func Held(path string) bool {
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	return time.Since(info.ModTime()) < staleAfter
+}
+
+//HeldAny - Tests whether a running malscan holds any of the leases matching the glob pattern
+func HeldAny(pattern string) bool {
+
+	paths, _ := filepath.Glob(pattern)
+	for _, path := range paths {
+		if Held(path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//Released - Returns a channel that is closed once no running malscan holds the lease at path
+func Released(path string) <-chan struct{} {
+
+	released := make(chan struct{})
+
+	go func() {
+		defer close(released)
+		for Held(path) {
+			time.Sleep(pollEvery)
+		}
+	}()
+
+	return released
+}
+
+//Sweep - Removes the stale leases matching the glob pattern
+func Sweep(pattern string) {
+
+	paths, _ := filepath.Glob(pattern)
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil && !Held(path) {
+			os.Remove(path)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package lease
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Tests taking, releasing and taking over stale leases
+
+*/
+
+//age - Makes the lease at path look like it was last touched d ago
+func age(t *testing.T, path string, d time.Duration) {
+
+	old := time.Now().Add(-d)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAcquire(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "run", "update-clamav.lease")
+
+	held, err := Acquire(path)
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	if !Held(path) {
+		t.Fatal("lease is not held once acquired")
+	}
+
+	if _, err := Acquire(path); err != ErrHeld {
+		t.Fatalf("Acquire of a held lease = %v, want %v", err, ErrHeld)
+	}
+
+	held.Release()
+	held.Release()
+
+	if Held(path) {
+		t.Fatal("lease is held once released")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("lease file is left once released: %v", err)
+	}
+
+	again, err := Acquire(path)
+	if err != nil {
+		t.Fatalf("Acquire of a released lease: %v", err)
+	}
+	again.Release()
+}
+
+func TestStale(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "daemon-dead.lease")
+
+	held, err := Acquire(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	held.Release()
+
+	//A malscan that died leaves its lease behind untouched
+	if err := ioutil.WriteFile(path, []byte("dead 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	age(t, path, staleAfter+time.Second)
+
+	if Held(path) {
+		t.Fatal("stale lease is held")
+	}
+	if HeldAny(filepath.Join(filepath.Dir(path), "daemon-*.lease")) {
+		t.Fatal("HeldAny matched a stale lease")
+	}
+
+	taken, err := Acquire(path)
+	if err != nil {
+		t.Fatalf("Acquire of a stale lease: %v", err)
+	}
+	defer taken.Release()
+
+	if !HeldAny(filepath.Join(filepath.Dir(path), "daemon-*.lease")) {
+		t.Fatal("HeldAny did not match the lease taken over")
+	}
+}
+
+func TestReleased(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "update-clamav.lease")
+
+	select {
+	case <-Released(path):
+	case <-time.After(time.Second):
+		t.Fatal("Released of a lease nobody holds did not close")
+	}
+
+	held, err := Acquire(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	released := Released(path)
+
+	select {
+	case <-released:
+		t.Fatal("Released closed while the lease is held")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	held.Release()
+
+	select {
+	case <-released:
+	case <-time.After(5 * pollEvery):
+		t.Fatal("Released did not close once the lease was released")
+	}
+}
+
+func TestSweep(t *testing.T) {
+
+	dir := t.TempDir()
+
+	live, err := Acquire(filepath.Join(dir, "process-live.lease"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Release()
+
+	dead := filepath.Join(dir, "process-dead.lease")
+	if err := ioutil.WriteFile(dead, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	age(t, dead, staleAfter+time.Second)
+
+	Sweep(filepath.Join(dir, "process-*.lease"))
+
+	if _, err := os.Stat(dead); !os.IsNotExist(err) {
+		t.Error("stale lease was not swept")
+	}
+	if !Held(filepath.Join(dir, "process-live.lease")) {
+		t.Error("live lease was swept")
+	}
+}
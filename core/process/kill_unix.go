@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package process
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+//isolate - Starts the process in its own process group so it can be killed along with any children
+func isolate(cmd *exec.Cmd) {
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+//kill - Kills the process group started by isolate
+func kill(cmd *exec.Cmd) {
+
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
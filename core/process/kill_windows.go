@@ -0,0 +1,12 @@
+package process
+
+import "os/exec"
+
+//isolate - Process groups are not used on windows
+func isolate(cmd *exec.Cmd) {}
+
+//kill - Kills the process, children are left to exit once their pipes close
+func kill(cmd *exec.Cmd) {
+
+	cmd.Process.Kill()
+}
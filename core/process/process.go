@@ -0,0 +1,121 @@
+package process
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"time"
+
+	file "malscan/core/utils/file"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains functions related to running plugins as local processes
+
+*/
+
+//Options - Settings for a process ran against a file
+type Options struct {
+	Args    []string      //Arguments passed before the path of the file
+	Env     []string      //Extra environment variables in KEY=value form
+	Timeout time.Duration //How long the process can run before it is killed, 0 for no timeout
+}
+
+//Result - Output of a process ran against a file
+type Result struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int64
+}
+
+//ErrTimeout - Returned when a process ran against a file is killed for running longer than its timeout
+var ErrTimeout = errors.New("process timed out")
+
+//ErrCancelled - Returned when a process ran against a file is killed because its context was cancelled
+var ErrCancelled = errors.New("process cancelled")
+
+//RunCommandOnFile - Used to run a local binary or script against the file passed into the function,
+//the path of the file is passed as the last argument. Returns the stdout, stderr and exit code of the process
+//If the process runs longer than the timeout in options it and its children are killed and ErrTimeout is returned,
+//if ctx is cancelled first they are killed and ErrCancelled is returned
+func RunCommandOnFile(parent context.Context, command string, fileToScanName *string, options Options) (result Result, err error) {
+
+	log.Debugf("running command:%s:against file:%s", command, *fileToScanName)
+
+	args := append(append([]string{}, options.Args...), file.Path(*fileToScanName))
+
+	return RunCommand(parent, command, args, *fileToScanName, options)
+}
+
+//RunCommand - Used to run a local binary or script with the arguments passed in, options.Args are ignored.
+//target names what the command is ran against in logs. Timeouts and cancelling work the same as RunCommandOnFile
+func RunCommand(parent context.Context, command string, args []string, target string, options Options) (result Result, err error) {
+
+	if parent.Err() != nil {
+		return Result{ExitCode: -1}, ErrCancelled
+	}
+
+	ctx := parent
+	if options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Timeout)
+		defer cancel()
+	}
+
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+
+	cmd := exec.Command(command, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = append([]string{"PATH=" + os.Getenv("PATH")}, options.Env...) //Plugins only see the environment set in plugins.toml
+	isolate(cmd)
+
+	result.ExitCode = -1
+
+	if err = cmd.Start(); err != nil {
+		return result, errors.Wrap(err, "error while starting command: "+command)
+	}
+
+	waited := make(chan error, 1)
+	go func() { waited <- cmd.Wait() }()
+
+	select {
+	case err = <-waited:
+	case <-ctx.Done():
+		kill(cmd)
+		<-waited
+		if parent.Err() != nil {
+			log.Warnf("command:%s:cancelled against:%s", command, target)
+			return result, ErrCancelled
+		}
+		log.Warnf("command:%s:timed out after:%s:against:%s", command, options.Timeout, target)
+		return result, ErrTimeout
+	}
+
+	result.Stdout = stdout.Bytes()
+	result.Stderr = stderr.Bytes()
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		//A non zero exit code is not a failure, engines commonly exit non zero when they detect malware
+		result.ExitCode = int64(exitErr.ExitCode())
+		err = nil
+	} else if err == nil {
+		result.ExitCode = int64(cmd.ProcessState.ExitCode())
+	}
+
+	if err != nil {
+		return result, errors.Wrap(err, "error while running command: "+command)
+	}
+
+	log.Debugf("finished running command:%s:against:%s:exit code:%d", command, target, result.ExitCode)
+
+	return result, nil
+}
@@ -0,0 +1,455 @@
+package quarantine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"malscan/config"
+	"malscan/core/utils"
+	file "malscan/core/utils/file"
+	hash "malscan/core/utils/hash"
+	"malscan/structs"
+
+	units "github.com/docker/go-units"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains the quarantine store, scanned files are kept wrapped at rest and addressed by their sha256
+
+*/
+
+//enforceEvery - How often retention is enforced while files are being quarantined
+const enforceEvery = time.Hour
+
+//Record - Metadata kept alongside every quarantined file
+type Record struct {
+	Sha256      string    `json:"sha256"`
+	Names       []string  `json:"names"` //Every name the file was quarantined under
+	Size        int64     `json:"size"`
+	Verdict     string    `json:"verdict"`
+	Results     []string  `json:"variants"`
+	Wrapping    string    `json:"wrapping"`
+	Quarantined time.Time `json:"quarantined"` //When the file was last quarantined
+}
+
+var (
+	mutex        sync.Mutex //Only one file at a time is written to the store
+	lastEnforced time.Time
+)
+
+//Dir - Returns the quarantine store directory, the configured dir or the default under the malscan base dir
+func Dir() string {
+
+	if config.Values.Quarantine.Dir != "" {
+		return config.Values.Quarantine.Dir
+	}
+
+	return utils.GetQuarantineDir()
+}
+
+//ShouldQuarantine - Tests the quarantine policy against a report, "*" in the verdicts quarantines every file
+func ShouldQuarantine(report structs.FullFileReport) bool {
+
+	if config.Values.Quarantine.Enabled != true {
+		return false
+	}
+
+	for _, verdict := range config.Values.Quarantine.Verdicts {
+		if verdict == "*" || verdict == report.File.Malware.Verdict {
+			return true
+		}
+	}
+
+	return false
+}
+
+//Dispose - Responsible for quarantining a scanned file if the quarantine policy says so and then removing it,
+//a file that could not be quarantined is left in place so it is not lost
+func Dispose(filename string, report structs.FullFileReport) {
+
+	if ShouldQuarantine(report) {
+		if err := Put(filename, report); err != nil {
+			log.WithFields(log.Fields{"err": err}).Errorf("quarantining:%s:leaving it in place", filename)
+			return
+		}
+	}
+
+	file.Remove(&filename)
+}
+
+//Put - Responsible for adding a file to the quarantine store, a file that is already quarantined has its record updated
+func Put(filename string, report structs.FullFileReport) error {
+
+	sum := report.File.Sha256
+	if sum == "" {
+		var err error
+		if sum, err = hash.GenerateFileSha256(&filename); err != nil {
+			return err
+		}
+	}
+
+	if !validSha256(sum) {
+		return errors.New("invalid sha256: " + sum)
+	}
+
+	blob := blobPath(sum)
+
+	mutex.Lock()
+
+	record, err := load(sum)
+	if os.IsNotExist(errors.Cause(err)) {
+		record = Record{Sha256: sum, Wrapping: wrapping()}
+		record.Size, err = write(file.Path(filename), blob, record.Wrapping)
+	}
+	if err != nil {
+		mutex.Unlock()
+		return err
+	}
+
+	name := report.File.Name
+	if name == "" {
+		name = filepath.Base(filename)
+	}
+	if !contains(record.Names, name) {
+		record.Names = append(record.Names, name)
+	}
+
+	record.Verdict = report.File.Malware.Verdict
+	record.Results = report.File.Malware.Results
+	record.Quarantined = time.Now()
+
+	err = save(record)
+
+	enforce := time.Since(lastEnforced) > enforceEvery
+	if enforce {
+		lastEnforced = time.Now()
+	}
+
+	mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	log.Infof("quarantined:%s:as:%s", filename, sum)
+
+	if enforce {
+		if _, err := Enforce(); err != nil {
+			log.WithFields(log.Fields{"err": err}).Error("enforcing quarantine retention")
+		}
+	}
+
+	return nil
+}
+
+//List - Returns every quarantined file, most recently quarantined first
+func List() ([]Record, error) {
+
+	var records []Record
+
+	err := filepath.Walk(Dir(), func(walked string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || filepath.Ext(walked) != ".json" {
+			return nil
+		}
+
+		record, err := load(strings.TrimSuffix(filepath.Base(walked), ".json"))
+		if err != nil {
+			log.WithFields(log.Fields{"err": err}).Warnf("reading quarantine record:%s", walked)
+			return nil
+		}
+
+		records = append(records, record)
+		return nil
+	})
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Quarantined.After(records[j].Quarantined)
+	})
+
+	return records, err
+}
+
+//PrintList - Prints every quarantined file as a table
+func PrintList() error {
+
+	records, err := List()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SHA256\tSIZE\tVERDICT\tQUARANTINED\tNAMES")
+
+	for _, record := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", record.Sha256, units.HumanSize(float64(record.Size)), record.Verdict,
+			record.Quarantined.Format(time.RFC3339), strings.Join(record.Names, ","))
+	}
+
+	return w.Flush()
+}
+
+//Restore - Responsible for unwrapping a quarantined file to dest, if dest is a directory the file is restored under its first name.
+//The restored file is checked against its sha256 and removed if it does not match
+func Restore(sum string, dest string) (string, error) {
+
+	sum = strings.ToLower(sum)
+
+	record, err := load(sum)
+	if err != nil {
+		return "", err
+	}
+
+	if info, err := os.Stat(dest); err == nil && info.IsDir() {
+		name := sum
+		if len(record.Names) > 0 {
+			name = filepath.Base(record.Names[0])
+		}
+		dest = filepath.Join(dest, name)
+	}
+
+	in, err := os.Open(blobPath(sum))
+	if err != nil {
+		return "", errors.Wrap(err, "error while opening quarantined file")
+	}
+	defer in.Close()
+
+	r, err := unwrapReader(in, record.Wrapping, config.Values.Quarantine.Key)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return "", errors.Wrap(err, "error while creating restored file")
+	}
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, h), r)
+	out.Close()
+
+	if err == nil && hex.EncodeToString(h.Sum(nil)) != sum {
+		err = errors.New("restored file does not match its sha256, check the quarantine key")
+	}
+	if err != nil {
+		os.Remove(dest)
+		return "", err
+	}
+
+	log.Infof("restored:%s:to:%s", sum, dest)
+
+	return dest, nil
+}
+
+//Purge - Responsible for removing every file quarantined longer ago than olderThan, returns how many were removed
+func Purge(olderThan time.Duration) (int, error) {
+
+	records, err := List()
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	purged := 0
+
+	for _, record := range records {
+		if record.Quarantined.Before(cutoff) {
+			if err := remove(record.Sha256); err != nil {
+				return purged, err
+			}
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+//Enforce - Responsible for applying the retention settings, files older than the retention are removed and then the oldest files
+//are removed until the store is within its max size. Returns how many files were removed
+func Enforce() (int, error) {
+
+	purged := 0
+
+	if config.Values.Quarantine.Retention != "" {
+		age, err := utils.ParseAge(config.Values.Quarantine.Retention)
+		if err != nil {
+			return 0, errors.Wrap(err, "invalid retention")
+		}
+		if purged, err = Purge(age); err != nil {
+			return purged, err
+		}
+	}
+
+	if config.Values.Quarantine.MaxSize == "" {
+		return purged, nil
+	}
+
+	maxSize, err := units.RAMInBytes(config.Values.Quarantine.MaxSize)
+	if err != nil {
+		return purged, errors.Wrap(err, "invalid max_size")
+	}
+
+	records, err := List()
+	if err != nil {
+		return purged, err
+	}
+
+	var total int64
+	for _, record := range records {
+		total += record.Size
+	}
+
+	//Records are newest first so the oldest are removed from the end
+	for i := len(records) - 1; i >= 0 && total > maxSize; i-- {
+		if err := remove(records[i].Sha256); err != nil {
+			return purged, err
+		}
+		total -= records[i].Size
+		purged++
+	}
+
+	return purged, nil
+}
+
+//wrapping - Returns the configured wrapping
+func wrapping() string {
+
+	if config.Values.Quarantine.Wrapping == "" {
+		return WrapAES
+	}
+
+	return config.Values.Quarantine.Wrapping
+}
+
+//blobPath - Returns where a quarantined file is stored, files are spread over directories named after the first two characters of their sha256
+func blobPath(sum string) string {
+
+	return filepath.Join(Dir(), sum[:2], sum)
+}
+
+//validSha256 - Tests that a sha256 is 64 hex characters so it can safely be used as a path
+func validSha256(sum string) bool {
+
+	decoded, err := hex.DecodeString(sum)
+
+	return err == nil && len(decoded) == sha256.Size
+}
+
+//write - Wraps the file into the store, the file is written under a temporary name and renamed so a partial file is never left in place
+func write(filename string, blob string, wrap string) (int64, error) {
+
+	if err := os.MkdirAll(filepath.Dir(blob), 0700); err != nil {
+		return 0, err
+	}
+
+	in, err := os.Open(filename)
+	if err != nil {
+		return 0, errors.Wrap(err, "error while opening file to quarantine")
+	}
+	defer in.Close()
+
+	out, err := ioutil.TempFile(filepath.Dir(blob), ".tmp-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(out.Name())
+
+	w, err := wrapWriter(out, wrap, config.Values.Quarantine.Key)
+	if err != nil {
+		out.Close()
+		return 0, err
+	}
+
+	size, err := io.Copy(w, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "error while writing quarantined file")
+	}
+
+	return size, os.Rename(out.Name(), blob)
+}
+
+//load - Reads the record of a quarantined file
+func load(sum string) (Record, error) {
+
+	var record Record
+
+	if !validSha256(sum) {
+		return record, errors.New("invalid sha256: " + sum)
+	}
+
+	data, err := ioutil.ReadFile(blobPath(sum) + ".json")
+	if err != nil {
+		return record, errors.Wrap(err, "no quarantined file with sha256: "+sum)
+	}
+
+	err = json.Unmarshal(data, &record)
+
+	return record, err
+}
+
+//save - Writes the record of a quarantined file
+func save(record Record) error {
+
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := blobPath(record.Sha256) + ".json.tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, blobPath(record.Sha256)+".json")
+}
+
+//remove - Removes a quarantined file and its record
+func remove(sum string) error {
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if err := os.Remove(blobPath(sum)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	log.Debugf("purged quarantined file:%s", sum)
+
+	return os.Remove(blobPath(sum) + ".json")
+}
+
+//contains - Tests whether a slice holds a string
+func contains(list []string, s string) bool {
+
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,143 @@
+package quarantine
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains functions related to wrapping quarantined files so they are not picked up by desktop av at rest.
+Wrapping is not meant to keep the files secret, the key is kept in the malscan config
+
+*/
+
+//Wrappings that can be applied to quarantined files
+const (
+	WrapXOR = "xor"
+	WrapAES = "aes"
+)
+
+//defaultKey - Key used when none is set in the config
+const defaultKey = "malscan"
+
+//magic - Written at the start of every wrapped file
+var magic = []byte("MSQ1")
+
+//wrapWriter - Returns a writer that wraps everything written to w, the header needed to unwrap it is written first
+func wrapWriter(w io.Writer, wrapping string, key string) (io.Writer, error) {
+
+	if key == "" {
+		key = defaultKey
+	}
+
+	if _, err := w.Write(magic); err != nil {
+		return nil, err
+	}
+
+	switch wrapping {
+	case WrapXOR:
+		return &xorWriter{w: w, key: []byte(key)}, nil
+	case WrapAES, "":
+		iv := make([]byte, aes.BlockSize)
+		if _, err := rand.Read(iv); err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(iv); err != nil {
+			return nil, err
+		}
+		stream, err := aesStream(key, iv)
+		if err != nil {
+			return nil, err
+		}
+		return &cipher.StreamWriter{S: stream, W: w}, nil
+	}
+
+	return nil, errors.New("unknown quarantine wrapping: " + wrapping)
+}
+
+//unwrapReader - Returns a reader that unwraps a file written by wrapWriter
+func unwrapReader(r io.Reader, wrapping string, key string) (io.Reader, error) {
+
+	if key == "" {
+		key = defaultKey
+	}
+
+	header := make([]byte, len(magic))
+	if _, err := io.ReadFull(r, header); err != nil || string(header) != string(magic) {
+		return nil, errors.New("not a quarantined file")
+	}
+
+	switch wrapping {
+	case WrapXOR:
+		return &xorReader{r: r, key: []byte(key)}, nil
+	case WrapAES, "":
+		iv := make([]byte, aes.BlockSize)
+		if _, err := io.ReadFull(r, iv); err != nil {
+			return nil, errors.Wrap(err, "error while reading quarantine iv")
+		}
+		stream, err := aesStream(key, iv)
+		if err != nil {
+			return nil, err
+		}
+		return &cipher.StreamReader{S: stream, R: r}, nil
+	}
+
+	return nil, errors.New("unknown quarantine wrapping: " + wrapping)
+}
+
+//aesStream - AES-256 in CTR mode keyed with the sha256 of the configured key
+func aesStream(key string, iv []byte) (cipher.Stream, error) {
+
+	sum := sha256.Sum256([]byte(key))
+
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewCTR(block, iv), nil
+}
+
+//xorWriter - XORs everything written with a repeating key
+type xorWriter struct {
+	w   io.Writer
+	key []byte
+	pos int
+}
+
+func (x *xorWriter) Write(p []byte) (int, error) {
+
+	buf := make([]byte, len(p))
+	for i, b := range p {
+		buf[i] = b ^ x.key[(x.pos+i)%len(x.key)]
+	}
+	x.pos += len(p)
+
+	return x.w.Write(buf)
+}
+
+//xorReader - XORs everything read with a repeating key
+type xorReader struct {
+	r   io.Reader
+	key []byte
+	pos int
+}
+
+func (x *xorReader) Read(p []byte) (int, error) {
+
+	n, err := x.r.Read(p)
+	for i := 0; i < n; i++ {
+		p[i] ^= x.key[(x.pos+i)%len(x.key)]
+	}
+	x.pos += n
+
+	return n, err
+}
@@ -0,0 +1,70 @@
+package scan
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	pconfig "malscan/plugins"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains functions related to one-shot scans of files and directories
+
+*/
+
+//Paths - Responsible for scanning each file passed in and every file found under each directory passed in
+//the report for each file is printed to stdout, unlike the watcher modes scanned files are never removed
+func Paths(paths []string) error {
+
+	log.Debugf("malscan is scanning:%d:paths", len(paths))
+
+	plugins := pconfig.PluginConfig{}
+	plugins = plugins.Load()
+	plugins.CollectVersions()
+
+	defer plugins.StartStalenessMonitor()()
+
+	for _, path := range paths {
+
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return errors.Wrap(err, "error while resolving path: "+path)
+		}
+
+		err = filepath.Walk(abs, func(walked string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil //Skip directories, symlinks, devices etc.
+			}
+			return printReport(plugins.RunEnabledConcurrent(walked))
+		})
+		if err != nil {
+			return errors.Wrap(err, "error while scanning path: "+path)
+		}
+	}
+
+	return nil
+}
+
+//printReport - Responsible for writing a file report to stdout as json
+func printReport(report interface{}) error {
+
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "error while marshaling report")
+	}
+
+	fmt.Println(string(b))
+
+	return nil
+}
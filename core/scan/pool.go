@@ -0,0 +1,275 @@
+package scan
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pconfig "malscan/plugins"
+	"malscan/structs"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains the worker pool used to schedule file scans
+
+*/
+
+//Settings - Controls how much work the pool does at once
+type Settings struct {
+	Files      int //Files scanned at once
+	Plugins    int //Plugins ran at once against a single file, 0 runs every plugin at once
+	QueueDepth int //Files that can be waiting for a worker before Submit blocks
+}
+
+//Stats - Snapshot of the work held by the pool
+type Stats struct {
+	Queued    int   `json:"queued"`
+	InFlight  int64 `json:"in_flight"`
+	Processed int64 `json:"processed"`
+}
+
+//Pool - Bounded pool of workers fed from a queue of files
+type Pool struct {
+	settings  Settings
+	plugins   pconfig.PluginConfig
+	queue     chan job
+	inFlight  int64
+	processed int64
+	wg        sync.WaitGroup
+	started   func(filename string)
+
+	ctx         context.Context //Cancelled once the shutdown deadline passes, stops the plugins of running scans
+	cancel      context.CancelFunc
+	stop        chan struct{} //Closed when the pool stops accepting files
+	stopOnce    sync.Once
+	stopped     chan struct{} //Closed once the pool has shut down
+	mutex       sync.Mutex
+	interrupted []string //Files whose scan was cancelled or never started as the pool was stopping
+}
+
+//job - A file waiting to be scanned along with what to do with its report
+type job struct {
+	filename string
+	done     func(filename string, report structs.FullFileReport)
+}
+
+//NewPool - Responsible for creating a pool and starting its workers
+func NewPool(settings Settings, plugins pconfig.PluginConfig) *Pool {
+
+	if settings.Files < 1 {
+		settings.Files = 1
+	}
+	if settings.QueueDepth < 0 {
+		settings.QueueDepth = 0
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	pool := &Pool{
+		settings: settings,
+		plugins:  plugins,
+		queue:    make(chan job, settings.QueueDepth),
+		ctx:      ctx,
+		cancel:   cancel,
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+
+	pool.wg.Add(settings.Files)
+	for i := 0; i < settings.Files; i++ {
+		go pool.worker()
+	}
+
+	log.Debugf("started scan pool:files:%d:plugins:%d:queue depth:%d", settings.Files, settings.Plugins, settings.QueueDepth)
+
+	return pool
+}
+
+//Submit - Responsible for queueing a file to be scanned, done is called with the report once the scan has finished
+//Submit blocks while the queue is full which applies backpressure to whatever is submitting files,
+//false is returned without queueing the file once the pool is shutting down
+func (pool *Pool) Submit(filename string, done func(filename string, report structs.FullFileReport)) bool {
+
+	if pool.stopping() {
+		return false
+	}
+
+	select {
+	case pool.queue <- job{filename: filename, done: done}:
+	case <-pool.stop:
+		return false
+	}
+
+	stats := pool.Stats()
+	log.WithFields(log.Fields{"queued": stats.Queued, "in_flight": stats.InFlight}).Debugf("queued:%s", filename)
+
+	return true
+}
+
+//TrySubmit - Responsible for queueing a file to be scanned without blocking, false is returned if the queue is full
+//or the pool is shutting down
+func (pool *Pool) TrySubmit(filename string, done func(filename string, report structs.FullFileReport)) bool {
+
+	if pool.stopping() {
+		return false
+	}
+
+	select {
+	case pool.queue <- job{filename: filename, done: done}:
+	default:
+		return false
+	}
+
+	stats := pool.Stats()
+	log.WithFields(log.Fields{"queued": stats.Queued, "in_flight": stats.InFlight}).Debugf("queued:%s", filename)
+
+	return true
+}
+
+//OnStart - Sets a function called with each file as a worker starts scanning it, must be set before any file is submitted
+func (pool *Pool) OnStart(started func(filename string)) {
+
+	pool.started = started
+}
+
+//Stats - Returns the current queue length and the amount of files being scanned
+func (pool *Pool) Stats() Stats {
+
+	return Stats{
+		Queued:    len(pool.queue),
+		InFlight:  atomic.LoadInt64(&pool.inFlight),
+		Processed: atomic.LoadInt64(&pool.processed),
+	}
+}
+
+//Cached - Returns the cached report for a sha256 that is still valid for the plugins of the pool
+func (pool *Pool) Cached(sha256 string) (structs.FullFileReport, bool) {
+
+	return pool.plugins.CachedReport(sha256)
+}
+
+//Stopped - Returns a channel that is closed once the pool has shut down, files submitted but not scanned by then never will be
+func (pool *Pool) Stopped() <-chan struct{} {
+
+	return pool.stopped
+}
+
+//Stop - Stops the pool accepting files without waiting for anything, a Submit blocked on a full queue returns false straight away.
+//Shutdown must still be called to drain the pool
+func (pool *Pool) Stop() {
+
+	pool.stopOnce.Do(func() {
+		close(pool.stop)
+	})
+}
+
+//Shutdown - Responsible for stopping the pool from accepting files, scans already running are given until deadline to finish
+//before they are cancelled which kills their plugins. Queued files are not started, the files of cancelled scans and of queued
+//files are returned so they can be requeued. done is never called for these files. Shutdown must only be called once
+func (pool *Pool) Shutdown(deadline time.Duration) (requeue []string) {
+
+	pool.Stop()
+
+	finished := make(chan struct{})
+	go func() {
+		pool.wg.Wait()
+		close(finished)
+	}()
+
+	log.Infof("waiting up to:%s:for:%d:running scans to finish", deadline, atomic.LoadInt64(&pool.inFlight))
+
+	select {
+	case <-finished:
+	case <-time.After(deadline):
+		log.Warnf("cancelling:%d:scans still running after:%s", atomic.LoadInt64(&pool.inFlight), deadline)
+		pool.cancel()
+		<-finished
+	}
+	pool.cancel()
+
+	pool.mutex.Lock()
+	requeue = append(requeue, pool.interrupted...)
+	pool.mutex.Unlock()
+
+	for drained := false; !drained; {
+		select {
+		case job := <-pool.queue:
+			requeue = append(requeue, job.filename)
+		default:
+			drained = true
+		}
+	}
+
+	close(pool.stopped)
+
+	return requeue
+}
+
+//stopping - Tests whether the pool has stopped accepting files
+func (pool *Pool) stopping() bool {
+
+	select {
+	case <-pool.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+//worker - Scans files from the queue until the pool is shutting down
+func (pool *Pool) worker() {
+
+	defer pool.wg.Done()
+
+	for !pool.stopping() {
+		select {
+		case job := <-pool.queue:
+			//select picks at random when both are ready, a job received once the pool is stopping is requeued instead
+			if pool.stopping() {
+				pool.mutex.Lock()
+				pool.interrupted = append(pool.interrupted, job.filename)
+				pool.mutex.Unlock()
+				return
+			}
+			pool.scan(job)
+		case <-pool.stop:
+			return
+		}
+	}
+}
+
+//scan - Scans a single file from the queue, the report of a scan that was cancelled is thrown away and the file is kept to be requeued
+func (pool *Pool) scan(job job) {
+
+	atomic.AddInt64(&pool.inFlight, 1)
+	defer atomic.AddInt64(&pool.inFlight, -1)
+
+	if pool.started != nil {
+		pool.started(job.filename)
+	}
+
+	report := pool.plugins.RunEnabledLimit(pool.ctx, job.filename, pool.settings.Plugins)
+
+	if pool.ctx.Err() != nil {
+		log.Warnf("scan interrupted:%s", job.filename)
+		pool.mutex.Lock()
+		pool.interrupted = append(pool.interrupted, job.filename)
+		pool.mutex.Unlock()
+		return
+	}
+
+	if job.done != nil {
+		job.done(job.filename, report)
+	}
+
+	atomic.AddInt64(&pool.processed, 1)
+
+	stats := pool.Stats()
+	log.WithFields(log.Fields{"queued": stats.Queued, "in_flight": stats.InFlight}).Debugf("finished:%s", job.filename)
+}
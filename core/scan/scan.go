@@ -1,14 +1,18 @@
 package scan
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"time"
 
 	"malscan/config"
+	"malscan/core/journal"
+	"malscan/core/quarantine"
 	"malscan/core/utils"
 	file "malscan/core/utils/file"
 	pconfig "malscan/plugins"
-
-	mime "malscan/core/utils/mime"
+	"malscan/structs"
 
 	"github.com/pkg/errors"
 	"github.com/radovskyb/watcher"
@@ -23,187 +27,118 @@ Purpose: Contains functions related to file scanning
 
 */
 
+//journalFile - Name of the scan journal in the journal dir
+const journalFile = "queue.journal"
+
 //Mode1 - Responsible for watching the malscan filestore
-//when a file enters the filestore the file is scanned using malscan plugins
+//files and plugins are ran one at a time
 func Mode1() {
 
 	log.Debug("malscan is ready to start scanning files in mode-1 ... waiting for files")
 
-	plugins := pconfig.PluginConfig{}
-	plugins = plugins.Load()
-
-	w := watcher.New()
-
-	// SetMaxEvents to 1 to allow at most 1 event's to be received
-	// on the Event channel per watching cycle.
-	// If SetMaxEvents is not set, the default is to send all events.
-	//w.SetMaxEvents(1)
-
-	// Only notify create events
-	w.FilterOps(watcher.Create)
-
-	go func() {
-		for {
-			select {
-			case event := <-w.Event:
-				log.Infof("file type:%s", mime.FileType(event.Name()))
-				plugins.RunEnabled(event.Name())
-				filename := event.Name()
-				file.Remove(&filename)
-			case err := <-w.Error:
-				log.Error(err)
-			case <-w.Closed:
-				return
-			}
-		}
-	}()
-
-	// Watch this folder for changes.
-
-	var folderToWatch string
-
-	if config.Values.Env.Filestore == "" {
-		folderToWatch = utils.GetFilestoreDir() //If filestore has not be set in the config file then use default filestore
-	} else {
-		folderToWatch = config.Values.Env.Filestore //If filestore is set use the user provided path in config file
-	}
-
-	if err := w.Add(folderToWatch); err != nil {
-		log.Fatal(err)
-	}
-
-	// Print a list of all of the files and folders currently
-	// being watched and their paths.
-	//for path, f := range w.WatchedFiles() {
-	//log.Debug("Watching: ", f.Name(), " at: ", path)
-	//}
-
-	// Start the watching process - it'll check for changes every 100ms.
-	if err := w.Start(time.Millisecond * 100); err != nil {
-		log.Fatal(err)
-	}
-
+	Watch(Settings{Files: 1, Plugins: 1, QueueDepth: config.Values.Env.QueueDepth})
 }
 
 //Mode2 - Responsible for watching the malscan filestore
-//when a file enters the filestore the file is scanned using malscan plugins
+//files are ran one at a time, plugins are ran concurrently
 func Mode2() {
 
 	log.Debug("malscan is ready to start scanning files in mode-2 ... waiting for files")
 
-	plugins := pconfig.PluginConfig{}
-	plugins = plugins.Load()
-
-	w := watcher.New()
+	Watch(Settings{Files: 1, Plugins: config.Values.Env.MaxPluginProc, QueueDepth: config.Values.Env.QueueDepth})
+}
 
-	// SetMaxEvents to 1 to allow at most 1 event's to be received
-	// on the Event channel per watching cycle.
-	// If SetMaxEvents is not set, the default is to send all events.
-	//w.SetMaxEvents(1)
+//Mode3 - Responsible for watching the malscan filestore
+//files are ran concurrently (limit set in config), plugins are ran concurrently
+func Mode3() {
 
-	// Only notify create events
-	w.FilterOps(watcher.Create)
+	log.Debug("malscan is ready to start scanning files in mode-3 ... waiting for files")
 
-	go func() {
-		for {
-			select {
-			case event := <-w.Event:
-				log.Infof("file type:%s", mime.FileType(event.Name()))
-				plugins.RunEnabledConcurrent(event.Name())
-				filename := event.Name()
-				file.Remove(&filename)
-			case err := <-w.Error:
-				log.Error(err)
-			case <-w.Closed:
-				return
-			}
-		}
-	}()
+	Watch(Settings{Files: config.Values.Env.MaxFileProc, Plugins: config.Values.Env.MaxPluginProc, QueueDepth: config.Values.Env.QueueDepth})
+}
 
-	// Watch this folder for changes.
+//Watch - Responsible for watching the malscan filestore
+//when a file enters the filestore it is queued on a worker pool with the settings passed in, once scanned it is quarantined
+//if the quarantine policy says so and removed from the filestore. Every file is recorded in the scan journal so files
+//that were pending when malscan stopped, and files already sitting in the filestore, are queued again on startup.
+//On SIGINT or SIGTERM the watcher is stopped and running scans are drained, scans that are cancelled stay pending in the journal
+func Watch(settings Settings) {
 
-	var folderToWatch string
+	plugins := pconfig.PluginConfig{}
+	plugins = plugins.Load()
+	plugins.CollectVersions()
 
-	if config.Values.Env.Filestore == "" {
-		folderToWatch = utils.GetFilestoreDir() //If filestore has not be set in the config file then use default filestore
-	} else {
-		folderToWatch = config.Values.Env.Filestore //If filestore is set use the user provided path in config file
-	}
+	defer plugins.StartDaemonLease()()
+	defer plugins.StartStalenessMonitor()()
+	defer plugins.StartContainerReaper()()
+	defer plugins.StartUpdater()()
 
-	if err := w.Add(folderToWatch); err != nil {
-		log.Fatal(err)
+	queue, err := journal.Open(filepath.Join(utils.GetJournalDir(), journalFile))
+	if err != nil {
+		log.Fatal(errors.Wrap(err, "error while opening scan journal"))
 	}
+	defer queue.Close()
 
-	// Print a list of all of the files and folders currently
-	// being watched and their paths.
-	//for path, f := range w.WatchedFiles() {
-	//log.Debug("Watching: ", f.Name(), " at: ", path)
-	//}
-
-	// Start the watching process - it'll check for changes every 100ms.
-	if err := w.Start(time.Millisecond * 100); err != nil {
-		log.Fatal(err)
-	}
+	pending := queue.Pending()
 
-}
+	pool := NewPool(settings, plugins)
 
-//Mode3 - Responsible for watching the malscan filestore
-//when a file enters the filestore the file is scanned using malscan plugins
-func Mode3() {
+	pool.OnStart(func(filename string) {
+		if err := queue.InProgress(filename); err != nil {
+			log.WithFields(log.Fields{"err": err}).Errorf("journaling:%s", filename)
+		}
+	})
 
-	log.Debug("malscan is ready to start scanning files in mode-3 ... waiting for files")
+	done := func(filename string, report structs.FullFileReport) {
+		quarantine.Dispose(filename, report)
+		if err := queue.Done(filename); err != nil {
+			log.WithFields(log.Fields{"err": err}).Errorf("journaling:%s", filename)
+		}
+	}
 
-	plugins := pconfig.PluginConfig{}
+	//submit - Queues a file the journal has not seen yet, Submit blocks while the queue is full
+	//which stops the watcher from polling until there is room. false is returned once the pool is shutting down
+	submit := func(filename string) bool {
+		if pool.stopping() {
+			return false //Left for the next start, which queues every file in the filestore
+		}
+		received, err := queue.Received(filename)
+		if err != nil {
+			log.WithFields(log.Fields{"err": err}).Errorf("journaling:%s", filename)
+		}
+		if received {
+			return pool.Submit(filename, done)
+		}
+		return true
+	}
 
-	plugins = plugins.Load()
+	shutdown := make(chan struct{}) //Closed once the pool has been drained
 
 	w := watcher.New()
 
-	// SetMaxEvents to 1 to allow at most 1 event's to be received
-	// on the Event channel per watching cycle.
-	// If SetMaxEvents is not set, the default is to send all events.
-	//w.SetMaxEvents(1)
-
 	// Only notify create events
 	w.FilterOps(watcher.Create)
 
-	filesProcessingCount := 0
-
 	go func() {
 		for {
 			select {
 			case event := <-w.Event:
-				log.Infof("file type:%s", mime.FileType(event.Name()))
-				done := make(chan bool)
-				go func() {
-					filename := event.Name()
-					filesProcessingCount++
-					for filesProcessingCount > config.Values.Env.MaxFileProc {
-						log.Debugf("max files:%d:processing", filesProcessingCount)
-						time.Sleep(time.Second * 5)
-					}
-					go plugins.RunEnabledConcurrentWithChannel(event.Name(), done)
-					<-done
-					file.Remove(&filename)
-					filesProcessingCount--
-				}()
+				submit(event.Name())
 			case err := <-w.Error:
 				log.Error(err)
 			case <-w.Closed:
+				requeue := pool.Shutdown(DrainTimeout())
+				for _, filename := range requeue {
+					log.Infof("file:%s:will be scanned when malscan next starts", filename)
+				}
+				close(shutdown)
 				return
 			}
 		}
 	}()
 
 	// Watch this folder for changes.
-
-	var folderToWatch string
-
-	if config.Values.Env.Filestore == "" {
-		folderToWatch = utils.GetFilestoreDir() //If filestore has not be set in the config file then use default filestore
-	} else {
-		folderToWatch = config.Values.Env.Filestore //If filestore is set use the user provided path in config file
-	}
+	folderToWatch := file.Filestore()
 
 	if err := w.Add(folderToWatch); err != nil {
 		log.Fatal(errors.Wrap(err, "error while opening filestore folder"))
@@ -215,9 +150,48 @@ func Mode3() {
 		log.Debug("Watching: ", f.Name(), " at: ", path)
 	}
 
+	//Files already in the filestore never fire a create event so they are queued after the pending files from the journal
+	go func() {
+		for _, filename := range pending {
+			if _, err := os.Stat(file.Path(filename)); err != nil {
+				log.Warnf("pending file:%s:is no longer in the filestore", filename)
+				queue.Done(filename)
+				continue
+			}
+			log.Infof("requeueing pending file:%s", filename)
+			if err := queue.Requeue(filename); err != nil {
+				log.WithFields(log.Fields{"err": err}).Errorf("journaling:%s", filename)
+			}
+			if !pool.Submit(filename, done) {
+				return //Shutting down, the file is still pending in the journal
+			}
+		}
+
+		existing, err := ioutil.ReadDir(folderToWatch)
+		if err != nil {
+			log.WithFields(log.Fields{"err": err}).Error("listing filestore")
+			return
+		}
+		for _, info := range existing {
+			if info.Mode().IsRegular() && !submit(info.Name()) {
+				return
+			}
+		}
+	}()
+
+	go func() {
+		sig := <-Signals()
+		log.Infof("received:%s:stopping malscan", sig)
+		//The pool is stopped first so a submit blocked on a full queue returns and the watcher can be closed
+		pool.Stop()
+		w.Close()
+	}()
+
 	// Start the watching process - it'll check for changes every 100ms.
 	if err := w.Start(time.Millisecond * 100); err != nil {
 		log.Fatal(errors.Wrap(err, "error while starting filestore watcher"))
 	}
 
+	<-shutdown
+
 }
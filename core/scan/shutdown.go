@@ -0,0 +1,49 @@
+package scan
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"malscan/config"
+	"malscan/core/utils"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains functions related to stopping malscan gracefully
+
+*/
+
+//defaultDrainTimeout - How long running scans are given to finish on shutdown when drain_timeout is not set
+const defaultDrainTimeout = time.Minute
+
+//Signals - Returns a channel that receives the signals malscan shuts down gracefully on
+func Signals() <-chan os.Signal {
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	return signals
+}
+
+//DrainTimeout - Returns how long running scans are given to finish on shutdown, set with drain_timeout in the malscan config
+func DrainTimeout() time.Duration {
+
+	if config.Values.Env.DrainTimeout == "" {
+		return defaultDrainTimeout
+	}
+
+	timeout, err := utils.ParseAge(config.Values.Env.DrainTimeout)
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Errorf("invalid drain_timeout, using:%s", defaultDrainTimeout)
+		return defaultDrainTimeout
+	}
+
+	return timeout
+}
@@ -0,0 +1,188 @@
+package schedule
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains a parser for cron style schedules
+
+*/
+
+//descriptors - Shorthands accepted in place of the five fields
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+//field - Range of values a field of a schedule can hold
+type field struct {
+	name string
+	min  int
+	max  int
+}
+
+var fields = []field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 6},
+}
+
+//Schedule - A parsed cron schedule, each field is a set of the values it matches
+type Schedule struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+
+	domAny bool //Day of month was *, only day of week restricts the day
+	dowAny bool //Day of week was *, only day of month restricts the day
+}
+
+//Parse - Responsible for parsing a cron schedule with five fields (minute hour day-of-month month day-of-week)
+//or one of the @hourly, @daily, @weekly, @monthly and @yearly shorthands. Fields accept *, lists, ranges and steps
+func Parse(spec string) (*Schedule, error) {
+
+	spec = strings.TrimSpace(spec)
+	if descriptor, ok := descriptors[spec]; ok {
+		spec = descriptor
+	}
+
+	parts := strings.Fields(spec)
+	if len(parts) != len(fields) {
+		return nil, errors.New("invalid schedule: " + spec + ", expected 5 fields (minute hour day-of-month month day-of-week)")
+	}
+
+	sets := make([]uint64, len(fields))
+	for i, part := range parts {
+		set, err := parseField(part, fields[i])
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid schedule: "+spec)
+		}
+		sets[i] = set
+	}
+
+	//Sunday can be written as 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+
+	return &Schedule{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		domAny: parts[2] == "*",
+		dowAny: parts[4] == "*",
+	}, nil
+}
+
+//parseField - Parses a comma separated list of values, ranges (a-b) and steps (*/n or a-b/n) into a set
+func parseField(part string, f field) (set uint64, err error) {
+
+	max := f.max
+	if f.name == "day of week" {
+		max = 7
+	}
+
+	for _, item := range strings.Split(part, ",") {
+
+		step := 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			if step, err = strconv.Atoi(item[i+1:]); err != nil || step < 1 {
+				return 0, errors.New("invalid step in " + f.name + ": " + item)
+			}
+			item = item[:i]
+		}
+
+		low, high := f.min, max
+		switch {
+		case item == "*":
+		case strings.Contains(item, "-"):
+			bounds := strings.SplitN(item, "-", 2)
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, errors.New("invalid range in " + f.name + ": " + item)
+			}
+			if high, err = strconv.Atoi(bounds[1]); err != nil {
+				return 0, errors.New("invalid range in " + f.name + ": " + item)
+			}
+		default:
+			if low, err = strconv.Atoi(item); err != nil {
+				return 0, errors.New("invalid value in " + f.name + ": " + item)
+			}
+			high = low
+			if step > 1 {
+				high = max //A step after a single value runs to the end of the range
+			}
+		}
+
+		if low < f.min || high > max || low > high {
+			return 0, errors.New(f.name + " out of range: " + item)
+		}
+
+		for value := low; value <= high; value += step {
+			set |= 1 << uint(value)
+		}
+	}
+
+	return set, nil
+}
+
+//Next - Returns the first time after t the schedule matches, a zero time is returned if it never matches (for example the 31st of February)
+func (s *Schedule) Next(t time.Time) time.Time {
+
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, t.Location()).Add(time.Minute)
+
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+//dayMatches - When both day fields are restricted a day matching either is enough, the same as cron
+func (s *Schedule) dayMatches(t time.Time) bool {
+
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	}
+
+	return dom || dow
+}
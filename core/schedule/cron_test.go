@@ -0,0 +1,132 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Tests parsing cron schedules and working out when they next run
+
+*/
+
+//date - Returns a time in UTC, used to keep the tables short
+func date(year int, month time.Month, day, hour, minute int) time.Time {
+
+	return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+}
+
+//bits - Returns a set holding the values passed in
+func bits(values ...int) uint64 {
+
+	var set uint64
+	for _, value := range values {
+		set |= 1 << uint(value)
+	}
+
+	return set
+}
+
+func TestParseField(t *testing.T) {
+
+	minute := fields[0]
+	dow := fields[4]
+
+	tests := []struct {
+		part string
+		f    field
+		want uint64
+	}{
+		{"5", minute, bits(5)},
+		{"1,2,30", minute, bits(1, 2, 30)},
+		{"10-13", minute, bits(10, 11, 12, 13)},
+		{"*/15", minute, bits(0, 15, 30, 45)},
+		{"1-9/4", minute, bits(1, 5, 9)},
+		{"50/5", minute, bits(50, 55)},
+		{"0-2,58-59", minute, bits(0, 1, 2, 58, 59)},
+		{"*", dow, bits(0, 1, 2, 3, 4, 5, 6, 7)},
+		{"5-7", dow, bits(5, 6, 7)},
+	}
+
+	for _, test := range tests {
+		got, err := parseField(test.part, test.f)
+		if err != nil {
+			t.Errorf("parseField(%q): %v", test.part, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("parseField(%q) = %b, want %b", test.part, got, test.want)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+
+	specs := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"1-x * * * *",
+		"@sometimes",
+	}
+
+	for _, spec := range specs {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) accepted an invalid schedule", spec)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"step", "*/15 * * * *", date(2021, 6, 1, 10, 7), date(2021, 6, 1, 10, 15)},
+		{"step wraps the hour", "*/15 * * * *", date(2021, 6, 1, 10, 50), date(2021, 6, 1, 11, 0)},
+		{"range with step", "0 9-17/4 * * *", date(2021, 6, 1, 13, 0), date(2021, 6, 1, 17, 0)},
+		{"range wraps the day", "0 9-17 * * *", date(2021, 6, 1, 18, 0), date(2021, 6, 2, 9, 0)},
+		{"strictly after", "0 0 * * *", date(2021, 6, 1, 0, 0), date(2021, 6, 2, 0, 0)},
+		{"seconds are dropped", "* * * * *", date(2021, 6, 1, 10, 7).Add(30 * time.Second), date(2021, 6, 1, 10, 8)},
+		{"sunday as 0", "30 2 * * 0", date(2021, 6, 1, 0, 0), date(2021, 6, 6, 2, 30)},
+		{"sunday as 7", "30 2 * * 7", date(2021, 6, 1, 0, 0), date(2021, 6, 6, 2, 30)},
+		{"weekdays", "0 0 * * 1-5", date(2021, 6, 5, 10, 0), date(2021, 6, 7, 0, 0)},
+		{"day of month only", "0 0 13 * *", date(2021, 6, 1, 0, 0), date(2021, 6, 13, 0, 0)},
+		{"day of month or week, week first", "0 0 13 * 5", date(2021, 6, 1, 0, 0), date(2021, 6, 4, 0, 0)},
+		{"day of month or week, month first", "0 0 2 * 5", date(2021, 6, 1, 0, 0), date(2021, 6, 2, 0, 0)},
+		{"month", "0 0 1 3 *", date(2021, 6, 1, 0, 0), date(2022, 3, 1, 0, 0)},
+		{"weekly", "@weekly", date(2021, 6, 1, 0, 0), date(2021, 6, 6, 0, 0)},
+		{"leap day", "0 0 29 2 *", date(2021, 3, 1, 0, 0), date(2024, 2, 29, 0, 0)},
+		{"31st skips short months", "0 0 31 * *", date(2021, 6, 1, 0, 0), date(2021, 7, 31, 0, 0)},
+		{"30th of february", "0 0 30 2 *", date(2021, 6, 1, 0, 0), time.Time{}},
+		{"31st of april", "0 0 31 4 *", date(2021, 6, 1, 0, 0), time.Time{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			schedule, err := Parse(test.spec)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", test.spec, err)
+			}
+
+			if got := schedule.Next(test.from); !got.Equal(test.want) {
+				t.Errorf("Next(%s) of %q = %s, want %s", test.from, test.spec, got, test.want)
+			}
+		})
+	}
+}
@@ -2,41 +2,49 @@ package scp
 
 import (
 	"os"
-
-	"malscan/config"
+	"time"
 
 	"github.com/bramvdbogaerde/go-scp"
 	"github.com/bramvdbogaerde/go-scp/auth"
 	"github.com/pkg/errors"
-	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
 )
 
-//Send - Accepts a local filepath and
-func Send(localfilepath string, dstip string, remotefilepath string) {
+//Send - Accepts the user and private key to authenticate with, a local filepath, the host to send it to and the path to copy it to
+//on that host. timeout bounds connecting and copying, 0 uses the go-scp defaults
+func Send(user string, pkey string, localfilepath string, dstip string, remotefilepath string, timeout time.Duration) error {
 
 	// Use SSH key authentication from the auth package
 	// we ignore the host key in this example, please change this if you use this library
-	clientConfig, _ := auth.PrivateKey(config.Values.Alert.ScpUser, config.Values.Alert.ScpPkey, ssh.InsecureIgnoreHostKey())
+	clientConfig, err := auth.PrivateKey(user, pkey, ssh.InsecureIgnoreHostKey())
+	if err != nil {
+		return errors.Wrap(err, "couldn't load the scp private key")
+	}
 
 	clientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey() //Must add this because of CVE-2017-3204
 
 	// Create a new SCP client
 	client := scp.NewClient(dstip, &clientConfig)
+	if timeout > 0 {
+		clientConfig.Timeout = timeout
+		client.Timeout = timeout
+	}
 
 	// Connect to the remote server
-	err := client.Connect()
+	err = client.Connect()
 	if err != nil {
-		log.Error(errors.Wrap(err, "couldn't establish a connection to the remote server"))
-		return
+		return errors.Wrap(err, "couldn't establish a connection to the remote server")
 	}
 
-	// Open a file
-	f, _ := os.Open(localfilepath)
-
 	//Close ssh connection after file has been sent
 	defer client.Close()
 
+	// Open a file
+	f, err := os.Open(localfilepath)
+	if err != nil {
+		return errors.Wrap(err, "error while opening file")
+	}
+
 	// Close the file after it has been copied
 	defer f.Close()
 
@@ -44,9 +52,9 @@ func Send(localfilepath string, dstip string, remotefilepath string) {
 	// Usage: CopyFile(fileReader, remotePath, permission)
 
 	err = client.CopyFile(f, remotefilepath, "0655")
-
 	if err != nil {
-		log.Error(errors.Wrap(err, "error while copying file"))
+		return errors.Wrap(err, "error while copying file")
 	}
 
+	return nil
 }
@@ -0,0 +1,349 @@
+package unpack
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains functions related to opening encrypted zip entries, both ZipCrypto and WinZip AES are supported
+
+*/
+
+const (
+	methodStore   = 0
+	methodDeflate = 8
+	methodAES     = 99
+
+	aesExtraID = 0x9901 //Extra field holding the WinZip AES strength and the real compression method
+	aesMacSize = 10     //WinZip AES entries end with the first 10 bytes of a HMAC-SHA1
+)
+
+//ErrPassword - Returned when none of the passwords open an encrypted entry
+var ErrPassword = errors.New("encrypted, could not open")
+
+//errWrongPassword - Returned when a password fails the check at the start of an encrypted entry
+var errWrongPassword = errors.New("wrong password")
+
+//openEncrypted - Opens an encrypted zip entry with password, errWrongPassword is returned if the password is wrong
+//ZipCrypto has a one byte password check so the data read can still fail its checksum if the password was wrong
+//The entry is read straight from archive, the file the zip was opened from
+func openEncrypted(archive io.ReaderAt, f *zip.File, password string) (io.ReadCloser, error) {
+
+	offset, err := f.DataOffset()
+	if err != nil {
+		return nil, err
+	}
+
+	raw := io.NewSectionReader(archive, offset, int64(f.CompressedSize64))
+
+	if f.Method == methodAES {
+		return openAES(f, raw, password)
+	}
+
+	return openZipCrypto(f, raw, password)
+}
+
+//openZipCrypto - Opens an entry encrypted with the traditional PKWARE cipher
+func openZipCrypto(f *zip.File, raw io.Reader, password string) (io.ReadCloser, error) {
+
+	keys := newZipCryptoKeys(password)
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(raw, header); err != nil {
+		return nil, errors.Wrap(err, "error while reading encryption header")
+	}
+	keys.decrypt(header)
+
+	//The last header byte is the high byte of the crc, or of the modified time when the crc is in a data descriptor
+	check := byte(f.CRC32 >> 24)
+	if f.Flags&0x8 != 0 {
+		check = byte(f.ModifiedTime >> 8)
+	}
+	if header[11] != check {
+		return nil, errWrongPassword
+	}
+
+	data := &zipCryptoReader{keys: keys, r: raw}
+
+	return decompress(f.Method, data, f.CRC32, true)
+}
+
+//openAES - Opens an entry encrypted with WinZip AES (AE-1 and AE-2)
+func openAES(f *zip.File, raw io.Reader, password string) (io.ReadCloser, error) {
+
+	version, keySize, method, err := aesExtra(f.Extra)
+	if err != nil {
+		return nil, err
+	}
+
+	saltSize := keySize / 2
+
+	salt := make([]byte, saltSize+2)
+	if _, err := io.ReadFull(raw, salt); err != nil {
+		return nil, errors.Wrap(err, "error while reading aes salt")
+	}
+
+	//The derived key holds the aes key, the hmac key and a two byte password verifier
+	key := pbkdf2.Key([]byte(password), salt[:saltSize], 1000, 2*keySize+2, sha1.New)
+
+	if subtle.ConstantTimeCompare(key[2*keySize:], salt[saltSize:]) != 1 {
+		return nil, errWrongPassword
+	}
+
+	dataSize := int64(f.CompressedSize64) - int64(saltSize) - 2 - aesMacSize
+	if dataSize < 0 {
+		return nil, errors.New("aes entry is too short")
+	}
+
+	block, err := aes.NewCipher(key[:keySize])
+	if err != nil {
+		return nil, err
+	}
+
+	data := &aesReader{
+		block: block,
+		mac:   hmac.New(sha1.New, key[keySize:2*keySize]),
+		data:  io.LimitReader(raw, dataSize),
+		raw:   raw,
+	}
+
+	//AE-2 leaves the crc empty and relies on the hmac alone
+	rc, err := decompress(method, data, f.CRC32, version == 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return &macReader{rc: rc, data: data}, nil
+}
+
+//aesExtra - Reads the WinZip AES extra field, returning the vendor version, the key size in bytes and the real compression method
+func aesExtra(extra []byte) (version int, keySize int, method uint16, err error) {
+
+	for len(extra) >= 4 {
+
+		id := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if len(extra) < 4+size {
+			break
+		}
+		field := extra[4 : 4+size]
+		extra = extra[4+size:]
+
+		if id != aesExtraID || size < 7 {
+			continue
+		}
+
+		version = int(binary.LittleEndian.Uint16(field[0:2]))
+		method = binary.LittleEndian.Uint16(field[5:7])
+
+		switch field[4] {
+		case 1:
+			keySize = 16
+		case 2:
+			keySize = 24
+		case 3:
+			keySize = 32
+		default:
+			return 0, 0, 0, errors.New("unknown aes strength")
+		}
+
+		return version, keySize, method, nil
+	}
+
+	return 0, 0, 0, errors.New("aes entry has no aes extra field")
+}
+
+//decompress - Wraps decrypted data in its decompressor, checking the crc once all of it has been read
+func decompress(method uint16, r io.Reader, crc uint32, checkCRC bool) (io.ReadCloser, error) {
+
+	var rc io.ReadCloser
+
+	switch method {
+	case methodStore:
+		rc = ioutil.NopCloser(r)
+	case methodDeflate:
+		rc = flate.NewReader(r)
+	default:
+		return nil, zip.ErrAlgorithm
+	}
+
+	if !checkCRC {
+		return rc, nil
+	}
+
+	return &checksumReader{rc: rc, hash: crc32.NewIEEE(), want: crc}, nil
+}
+
+//zipCryptoKeys - State of the traditional PKWARE cipher
+type zipCryptoKeys [3]uint32
+
+//newZipCryptoKeys - Initialises the cipher keys from a password
+func newZipCryptoKeys(password string) *zipCryptoKeys {
+
+	keys := &zipCryptoKeys{0x12345678, 0x23456789, 0x34567890}
+	for i := 0; i < len(password); i++ {
+		keys.update(password[i])
+	}
+
+	return keys
+}
+
+//update - Mixes a plaintext byte into the keys
+func (keys *zipCryptoKeys) update(b byte) {
+
+	keys[0] = crc32.IEEETable[byte(keys[0])^b] ^ (keys[0] >> 8)
+	keys[1] = (keys[1]+(keys[0]&0xff))*134775813 + 1
+	keys[2] = crc32.IEEETable[byte(keys[2])^byte(keys[1]>>24)] ^ (keys[2] >> 8)
+}
+
+//decrypt - Decrypts buf in place
+func (keys *zipCryptoKeys) decrypt(buf []byte) {
+
+	for i, c := range buf {
+		temp := uint16(keys[2]) | 2
+		buf[i] = c ^ byte((temp*(temp^1))>>8)
+		keys.update(buf[i])
+	}
+}
+
+//zipCryptoReader - Decrypts a ZipCrypto stream as it is read
+type zipCryptoReader struct {
+	keys *zipCryptoKeys
+	r    io.Reader
+}
+
+func (z *zipCryptoReader) Read(p []byte) (int, error) {
+
+	n, err := z.r.Read(p)
+	z.keys.decrypt(p[:n])
+
+	return n, err
+}
+
+//aesReader - Decrypts a WinZip AES stream as it is read and checks its hmac at the end
+//WinZip AES is CTR mode with a little endian counter starting at 1, which crypto/cipher does not provide
+type aesReader struct {
+	block   cipher.Block
+	mac     hash.Hash
+	data    io.Reader //Encrypted data without the hmac
+	raw     io.Reader //Whole entry, the hmac follows the data
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	used    int //Bytes of the current keystream block already used
+	started bool
+	checked bool //The hmac has been checked, the whole entry has been read
+}
+
+func (a *aesReader) Read(p []byte) (int, error) {
+
+	if a.checked {
+		return 0, io.EOF
+	}
+
+	n, err := a.data.Read(p)
+
+	a.mac.Write(p[:n])
+
+	for i := 0; i < n; i++ {
+		if !a.started || a.used == aes.BlockSize {
+			a.next()
+		}
+		p[i] ^= a.stream[a.used]
+		a.used++
+	}
+
+	if err == io.EOF {
+		want := make([]byte, aesMacSize)
+		if _, err := io.ReadFull(a.raw, want); err != nil {
+			return n, errors.Wrap(err, "error while reading aes authentication code")
+		}
+		if !hmac.Equal(a.mac.Sum(nil)[:aesMacSize], want) {
+			return n, zip.ErrChecksum
+		}
+		a.checked = true
+	}
+
+	return n, err
+}
+
+//next - Generates the next block of keystream
+func (a *aesReader) next() {
+
+	a.started = true
+
+	for i := range a.counter {
+		a.counter[i]++
+		if a.counter[i] != 0 {
+			break
+		}
+	}
+
+	a.block.Encrypt(a.stream[:], a.counter[:])
+	a.used = 0
+}
+
+//macReader - Reads the rest of a WinZip AES entry once its decompressor is done so the hmac is always checked,
+//deflate can stop before the encrypted data has been read to the end
+type macReader struct {
+	rc   io.ReadCloser
+	data io.Reader
+}
+
+func (m *macReader) Read(p []byte) (int, error) {
+
+	n, err := m.rc.Read(p)
+
+	if err == io.EOF {
+		if _, err := io.Copy(ioutil.Discard, m.data); err != nil {
+			return n, err
+		}
+	}
+
+	return n, err
+}
+
+func (m *macReader) Close() error {
+
+	return m.rc.Close()
+}
+
+//checksumReader - Checks the crc of everything read once the end of the entry is reached
+type checksumReader struct {
+	rc   io.ReadCloser
+	hash hash.Hash32
+	want uint32
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+
+	n, err := c.rc.Read(p)
+	c.hash.Write(p[:n])
+
+	if err == io.EOF && c.hash.Sum32() != c.want {
+		return n, zip.ErrChecksum
+	}
+
+	return n, err
+}
+
+func (c *checksumReader) Close() error {
+
+	return c.rc.Close()
+}
@@ -0,0 +1,184 @@
+package unpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Tests opening encrypted zip entries. The archives in testdata hold testdata/sample.txt encrypted with the password
+"infected" and were made with other tools so the ciphers are checked against real archives:
+  zipcrypto.zip            zip -P infected
+  zipcrypto-libarchive.zip bsdtar --format zip --options zip:encryption=traditional --passphrase infected
+  aes256.zip               bsdtar --format zip --options zip:encryption=aes256 --passphrase infected
+  aes256-stored.zip        bsdtar --format zip --options zip:encryption=aes256,zip:compression=store --passphrase infected
+
+*/
+
+const testPassword = "infected"
+
+//testdata - Returns the contents of a file in testdata
+func testdata(t *testing.T, name string) []byte {
+
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+//unpackEncrypted - Writes archive to a temporary directory and unpacks it with passwords
+func unpackEncrypted(t *testing.T, archive []byte, passwords []string) ([]Entry, []Archive) {
+
+	dir := t.TempDir()
+
+	filename := filepath.Join(dir, "archive.zip")
+	if err := ioutil.WriteFile(filename, archive, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, archives, err := Unpack(filename, filepath.Join(dir, "unpacked"), Limits{}, passwords)
+	if err != nil {
+		t.Fatalf("Unpack: %v", err)
+	}
+
+	return entries, archives
+}
+
+//asAE2 - Rewrites the WinZip AES extra fields of an archive to vendor version AE-2, which leaves the crc unchecked and relies on the hmac alone.
+//The extra fields are not covered by the hmac so the entry still opens
+func asAE2(t *testing.T, archive []byte) []byte {
+
+	header := []byte{0x01, 0x99, 0x07, 0x00, 0x01, 0x00, 'A', 'E'}
+
+	if bytes.Count(archive, header) != 2 {
+		t.Fatalf("archive holds %d aes extra fields, want the local and central one", bytes.Count(archive, header))
+	}
+
+	ae2 := append([]byte(nil), header...)
+	binary.LittleEndian.PutUint16(ae2[4:6], 2)
+
+	return bytes.Replace(archive, header, ae2, -1)
+}
+
+//aesData - Returns the offset and size of the encrypted data of the only entry in a WinZip AES archive, between the salt and the hmac
+func aesData(t *testing.T, archive []byte) (int, int) {
+
+	local := archive[:30]
+	if binary.LittleEndian.Uint32(local[0:4]) != 0x04034b50 {
+		t.Fatal("archive does not start with a local file header")
+	}
+
+	nameSize := int(binary.LittleEndian.Uint16(local[26:28]))
+	extraSize := int(binary.LittleEndian.Uint16(local[28:30]))
+
+	//AES-256 has a 16 byte salt and a 2 byte password verifier, the data descriptor follows the 10 byte hmac
+	start := 30 + nameSize + extraSize + 16 + 2
+	end := bytes.Index(archive[start:], []byte{0x50, 0x4b, 0x07, 0x08}) + start - aesMacSize
+
+	return start, end - start
+}
+
+func TestOpenEncrypted(t *testing.T) {
+
+	sample := testdata(t, "sample.txt")
+
+	tests := []struct {
+		name    string
+		archive []byte
+	}{
+		{"zipcrypto", testdata(t, "zipcrypto.zip")},
+		{"zipcrypto libarchive", testdata(t, "zipcrypto-libarchive.zip")},
+		{"aes256 deflate", testdata(t, "aes256.zip")},
+		{"aes256 stored", testdata(t, "aes256-stored.zip")},
+		{"aes256 deflate AE-2", asAE2(t, testdata(t, "aes256.zip"))},
+		{"aes256 stored AE-2", asAE2(t, testdata(t, "aes256-stored.zip"))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			entries, archives := unpackEncrypted(t, test.archive, []string{"wrong", "password", testPassword})
+
+			if len(entries) != 1 {
+				t.Fatalf("Unpack returned %d entries, want 1", len(entries))
+			}
+
+			got, err := ioutil.ReadFile(entries[0].Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, sample) {
+				t.Fatalf("unpacked %d bytes that do not match sample.txt", len(got))
+			}
+
+			if len(archives) != 1 || archives[0].Password != testPassword || len(archives[0].Locked) != 0 {
+				t.Fatalf("Unpack recorded %+v, want the archive opened with %q", archives, testPassword)
+			}
+		})
+	}
+}
+
+func TestWrongPassword(t *testing.T) {
+
+	for _, name := range []string{"zipcrypto.zip", "zipcrypto-libarchive.zip", "aes256.zip", "aes256-stored.zip"} {
+		t.Run(name, func(t *testing.T) {
+
+			entries, archives := unpackEncrypted(t, testdata(t, name), []string{"wrong", "password"})
+
+			if len(entries) != 0 {
+				t.Fatalf("Unpack returned %d entries, want none", len(entries))
+			}
+			if len(archives) != 1 || archives[0].Password != "" || len(archives[0].Locked) != 1 || archives[0].Locked[0] != "sample.txt" {
+				t.Fatalf("Unpack recorded %+v, want sample.txt locked", archives)
+			}
+		})
+	}
+}
+
+func TestTamperedAES(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		archive []byte
+	}{
+		{"AE-1 deflate", testdata(t, "aes256.zip")},
+		{"AE-1 stored", testdata(t, "aes256-stored.zip")},
+		{"AE-2 deflate", asAE2(t, testdata(t, "aes256.zip"))},
+		{"AE-2 stored", asAE2(t, testdata(t, "aes256-stored.zip"))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			start, size := aesData(t, test.archive)
+
+			flips := map[string]int{
+				"data":           start + size/2,
+				"last data byte": start + size - 1, //Deflate can finish without reading it
+				"hmac":           start + size,
+			}
+
+			for where, offset := range flips {
+				tampered := append([]byte(nil), test.archive...)
+				tampered[offset] ^= 0x01
+
+				entries, archives := unpackEncrypted(t, tampered, []string{testPassword})
+
+				if len(entries) != 0 {
+					t.Errorf("Unpack accepted an entry with a tampered %s", where)
+				}
+				if len(archives) != 1 || len(archives[0].Locked) != 1 {
+					t.Errorf("Unpack recorded %+v for a tampered %s, want the entry locked", archives, where)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,459 @@
+package unpack
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains functions related to recursively unpacking archives so the files inside them can be scanned
+
+*/
+
+//Archive formats that can be unpacked
+const (
+	FormatZip   = "zip"
+	FormatTar   = "tar"
+	FormatGzip  = "gzip"
+	FormatBzip2 = "bzip2"
+)
+
+//minRatioSize - Archives can always unpack this much regardless of max ratio, so tiny archives of small files are not flagged
+const minRatioSize = 1024 * 1024
+
+//Limits - Protects against zip bombs, every limit applies to the whole tree of archives unpacked from one file
+type Limits struct {
+	MaxDepth     int     //How many archives deep to unpack
+	MaxTotalSize int64   //Total bytes that can be unpacked
+	MaxEntries   int     //Total files that can be unpacked
+	MaxRatio     float64 //Largest unpacked size to archive size ratio for a single archive
+}
+
+//DefaultLimits - Limits used for any limit that is not set
+var DefaultLimits = Limits{
+	MaxDepth:     3,
+	MaxTotalSize: 512 * 1024 * 1024,
+	MaxEntries:   1000,
+	MaxRatio:     100,
+}
+
+//Limit errors, unpacking stops as soon as one of these is hit
+var (
+	ErrTotalSize = errors.New("unpacked size exceeds max_total_size")
+	ErrEntries   = errors.New("unpacked file count exceeds max_entries")
+	ErrRatio     = errors.New("compression ratio exceeds max_ratio")
+)
+
+//Entry - A file unpacked from an archive
+type Entry struct {
+	Path   string //Where the file was unpacked to on disk
+	Name   string //Path of the file inside the archive, nested archives are joined with "/"
+	Parent string //Path on disk of the archive the file was unpacked from
+	Depth  int    //1 for files directly inside the file passed to Unpack
+}
+
+//Archive - Records the encrypted entries of an archive that was unpacked
+type Archive struct {
+	Path     string   //Path on disk of the archive
+	Password string   //Password that opened its encrypted entries
+	Locked   []string //Encrypted entries none of the passwords could open
+}
+
+//unpacker - State shared while unpacking one tree of archives
+type unpacker struct {
+	dir       string
+	limits    Limits
+	passwords []string
+	total     int64
+	entries   []Entry
+	archives  []Archive
+}
+
+//Format - Detects the archive format of a file from its magic bytes, "" is returned if the file is not an archive that can be unpacked
+func Format(filename string) string {
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	header := make([]byte, 512)
+	n, _ := io.ReadFull(f, header)
+
+	return format(header[:n])
+}
+
+//format - Detects the archive format from the first 512 bytes of a file
+func format(header []byte) string {
+
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return FormatZip
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return FormatGzip
+	case bytes.HasPrefix(header, []byte("BZh")):
+		return FormatBzip2
+	case isTar(header):
+		return FormatTar
+	}
+
+	return ""
+}
+
+//isTar - Tests for the ustar magic of a tar header
+func isTar(header []byte) bool {
+
+	return len(header) >= 262 && bytes.Equal(header[257:262], []byte("ustar"))
+}
+
+//Unpack - Responsible for recursively unpacking filename into dir, every file unpacked is returned in the order it was
+//unpacked so an archive always comes before the files inside it. Archives deeper than the max depth are left packed.
+//Encrypted zip entries are opened with the first of the passwords that works, archives that had encrypted entries are returned
+//with the password used. If a limit is hit the files unpacked so far are returned along with the limit error, damaged archives
+//are logged and skipped
+func Unpack(filename string, dir string, limits Limits, passwords []string) ([]Entry, []Archive, error) {
+
+	limits = limits.withDefaults()
+
+	u := &unpacker{dir: dir, limits: limits, passwords: passwords}
+
+	queue := []Entry{{Path: filename}}
+
+	for len(queue) > 0 {
+
+		archive := queue[0]
+		queue = queue[1:]
+
+		archiveFormat := Format(archive.Path)
+		if archiveFormat == "" {
+			continue
+		}
+
+		if archive.Depth >= limits.MaxDepth {
+			log.Debugf("not unpacking:%s:max depth of:%d:reached", archive.Name, limits.MaxDepth)
+			continue
+		}
+
+		children, err := u.extract(archive, archiveFormat)
+		queue = append(queue, children...)
+
+		if err == ErrTotalSize || err == ErrEntries || err == ErrRatio {
+			return u.entries, u.archives, err
+		}
+		if err != nil {
+			log.WithFields(log.Fields{"err": err}).Warnf("unpacking:%s", archive.Path)
+		}
+	}
+
+	return u.entries, u.archives, nil
+}
+
+//withDefaults - Fills in any limit that is not set from the default limits
+func (limits Limits) withDefaults() Limits {
+
+	if limits.MaxDepth <= 0 {
+		limits.MaxDepth = DefaultLimits.MaxDepth
+	}
+	if limits.MaxTotalSize <= 0 {
+		limits.MaxTotalSize = DefaultLimits.MaxTotalSize
+	}
+	if limits.MaxEntries <= 0 {
+		limits.MaxEntries = DefaultLimits.MaxEntries
+	}
+	if limits.MaxRatio <= 0 {
+		limits.MaxRatio = DefaultLimits.MaxRatio
+	}
+
+	return limits
+}
+
+//extract - Unpacks a single archive, the files unpacked are returned so they can be unpacked in turn
+func (u *unpacker) extract(archive Entry, archiveFormat string) ([]Entry, error) {
+
+	info, err := os.Stat(archive.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	//Bytes this archive can unpack before its compression ratio is too high
+	budget := int64(u.limits.MaxRatio * float64(info.Size()))
+	if budget < minRatioSize {
+		budget = minRatioSize
+	}
+
+	if archiveFormat == FormatZip {
+		return u.extractZip(archive, &budget)
+	}
+
+	f, err := os.Open(archive.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if archiveFormat == FormatTar {
+		return u.extractTar(archive, f, &budget)
+	}
+
+	//A compressed file is named after the archive without its extension unless the gzip header holds the original name
+	base := archive.Name
+	if base == "" {
+		base = filepath.Base(archive.Path)
+	}
+
+	var r io.Reader
+	name := strings.TrimSuffix(path.Base(base), path.Ext(base))
+
+	if archiveFormat == FormatGzip {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, errors.Wrap(err, "error while reading gzip header")
+		}
+		defer gz.Close()
+		if gz.Name != "" {
+			name = gz.Name
+		}
+		r = gz
+	} else {
+		r = bzip2.NewReader(f)
+	}
+
+	//A compressed tar is unpacked as one archive rather than a compressed file holding a tar
+	br := bufio.NewReaderSize(r, 512)
+	header, _ := br.Peek(512)
+	if isTar(header) {
+		return u.extractTar(archive, br, &budget)
+	}
+
+	if name == "" || name == "." || name == "/" {
+		name = "data"
+	}
+
+	child, err := u.write(archive, name, br, &budget)
+	if err != nil {
+		return nil, err
+	}
+
+	return []Entry{child}, nil
+}
+
+//extractZip - Unpacks every regular file in a zip archive
+func (u *unpacker) extractZip(archive Entry, budget *int64) ([]Entry, error) {
+
+	//The archive is opened by hand as encrypted entries are read from it directly
+	file, err := os.Open(archive.Path)
+	if err != nil {
+		return nil, errors.Wrap(err, "error while opening zip")
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "error while opening zip")
+	}
+
+	r, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		return nil, errors.Wrap(err, "error while opening zip")
+	}
+
+	var children []Entry
+
+	record := Archive{Path: archive.Path}
+	defer func() {
+		if record.Password != "" || len(record.Locked) > 0 {
+			u.archives = append(u.archives, record)
+		}
+	}()
+
+	for _, f := range r.File {
+
+		if !f.Mode().IsRegular() {
+			continue
+		}
+
+		//Bit 0 of the flags marks an encrypted entry
+		if f.Flags&0x1 != 0 {
+			child, password, err := u.writeEncrypted(archive, file, f, budget)
+			if err == ErrTotalSize || err == ErrEntries || err == ErrRatio {
+				return children, err
+			}
+			if err == ErrPassword {
+				log.Warnf("not unpacking:%s:from:%s:%s", f.Name, archive.Path, err)
+				record.Locked = append(record.Locked, f.Name)
+				continue
+			}
+			if err != nil {
+				log.WithFields(log.Fields{"err": err}).Warnf("unpacking:%s:from:%s", f.Name, archive.Path)
+				continue
+			}
+			if record.Password == "" {
+				record.Password = password
+			}
+			children = append(children, child)
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			log.WithFields(log.Fields{"err": err}).Warnf("opening:%s:in:%s", f.Name, archive.Path)
+			continue
+		}
+
+		child, err := u.write(archive, f.Name, rc, budget)
+		rc.Close()
+
+		if err == ErrTotalSize || err == ErrEntries || err == ErrRatio {
+			return children, err
+		}
+		if err != nil {
+			log.WithFields(log.Fields{"err": err}).Warnf("unpacking:%s:from:%s", f.Name, archive.Path)
+			continue
+		}
+
+		children = append(children, child)
+	}
+
+	return children, nil
+}
+
+//writeEncrypted - Tries each password on an encrypted zip entry, returning the entry and the password that opened it.
+//A password that passes the check at the start of the entry but fails the checksum is treated as wrong and the next one is tried
+func (u *unpacker) writeEncrypted(archive Entry, file io.ReaderAt, f *zip.File, budget *int64) (Entry, string, error) {
+
+	for _, password := range u.passwords {
+
+		rc, err := openEncrypted(file, f, password)
+		if err == errWrongPassword {
+			continue
+		}
+		if err != nil {
+			return Entry{}, "", err
+		}
+
+		child, err := u.write(archive, f.Name, rc, budget)
+		rc.Close()
+
+		if err == nil {
+			return child, password, nil
+		}
+		if err == ErrTotalSize || err == ErrEntries || err == ErrRatio {
+			return Entry{}, "", err
+		}
+	}
+
+	return Entry{}, "", ErrPassword
+}
+
+//extractTar - Unpacks every regular file in a tar stream, links and devices are skipped
+func (u *unpacker) extractTar(archive Entry, r io.Reader, budget *int64) ([]Entry, error) {
+
+	tr := tar.NewReader(r)
+
+	var children []Entry
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return children, nil
+		}
+		if err != nil {
+			return children, errors.Wrap(err, "error while reading tar")
+		}
+
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		child, err := u.write(archive, header.Name, tr, budget)
+		if err != nil {
+			return children, err
+		}
+
+		children = append(children, child)
+	}
+}
+
+//write - Writes a single unpacked file into its own directory under the unpack dir, enforcing the entry, size and ratio limits
+func (u *unpacker) write(archive Entry, name string, r io.Reader, budget *int64) (Entry, error) {
+
+	if len(u.entries) >= u.limits.MaxEntries {
+		return Entry{}, ErrEntries
+	}
+
+	//Each file gets its own directory so files with the same name in different archives do not collide
+	dir := filepath.Join(u.dir, strconv.Itoa(len(u.entries)+1))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return Entry{}, err
+	}
+
+	onDisk := filepath.Join(dir, cleanName(name))
+
+	f, err := os.OpenFile(onDisk, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	remaining := u.limits.MaxTotalSize - u.total
+	max := remaining
+	if *budget < max {
+		max = *budget
+	}
+
+	n, err := io.Copy(f, io.LimitReader(r, max+1))
+	f.Close()
+
+	if n > max {
+		os.RemoveAll(dir)
+		if n > remaining {
+			return Entry{}, ErrTotalSize
+		}
+		return Entry{}, ErrRatio
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return Entry{}, err
+	}
+
+	u.total += n
+	*budget -= n
+
+	entry := Entry{
+		Path:   onDisk,
+		Name:   path.Join(archive.Name, strings.TrimPrefix(path.Clean("/"+name), "/")),
+		Parent: archive.Path,
+		Depth:  archive.Depth + 1,
+	}
+
+	u.entries = append(u.entries, entry)
+
+	return entry, nil
+}
+
+//cleanName - Returns the last element of a name from an archive so entries can never be written outside the unpack dir
+func cleanName(name string) string {
+
+	name = path.Base(path.Clean("/" + strings.Replace(name, "\\", "/", -1)))
+	if name == "/" || name == "." || name == ".." {
+		return "file"
+	}
+
+	return name
+}
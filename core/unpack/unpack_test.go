@@ -0,0 +1,220 @@
+package unpack
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Tests the limits that protect unpacking from zip bombs and that entries can not be written outside the unpack dir
+
+*/
+
+//file - A file to put in a test archive
+type file struct {
+	name string
+	data []byte
+}
+
+//zipOf - Returns a deflated zip archive holding files
+func zipOf(t *testing.T, files ...file) []byte {
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(f.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+//unpackTemp - Writes archive to a temporary directory and unpacks it there with limits
+func unpackTemp(t *testing.T, archive []byte, limits Limits) (string, []Entry, error) {
+
+	dir := t.TempDir()
+
+	filename := filepath.Join(dir, "archive.zip")
+	if err := ioutil.WriteFile(filename, archive, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "unpacked")
+	entries, _, err := Unpack(filename, out, limits, nil)
+
+	return out, entries, err
+}
+
+//names - Returns the names of the entries
+func names(entries []Entry) []string {
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+
+	return names
+}
+
+func TestMaxDepth(t *testing.T) {
+
+	inner := zipOf(t, file{"sample.txt", []byte("malscan")})
+	middle := zipOf(t, file{"inner.zip", inner})
+	outer := zipOf(t, file{"middle.zip", middle})
+
+	tests := []struct {
+		depth int
+		want  string
+	}{
+		{1, "middle.zip"},
+		{2, "middle.zip,middle.zip/inner.zip"},
+		{3, "middle.zip,middle.zip/inner.zip,middle.zip/inner.zip/sample.txt"},
+	}
+
+	for _, test := range tests {
+		_, entries, err := unpackTemp(t, outer, Limits{MaxDepth: test.depth})
+		if err != nil {
+			t.Fatalf("Unpack with max depth %d: %v", test.depth, err)
+		}
+		if got := strings.Join(names(entries), ","); got != test.want {
+			t.Errorf("Unpack with max depth %d = %s, want %s", test.depth, got, test.want)
+		}
+	}
+
+	_, entries, _ := unpackTemp(t, outer, Limits{MaxDepth: 3})
+	if last := entries[len(entries)-1]; last.Depth != 3 {
+		t.Errorf("depth of %s = %d, want 3", last.Name, last.Depth)
+	}
+}
+
+func TestMaxEntries(t *testing.T) {
+
+	var files []file
+	for i := 0; i < 5; i++ {
+		files = append(files, file{"sample" + strconv.Itoa(i), []byte("malscan")})
+	}
+	archive := zipOf(t, files...)
+
+	_, entries, err := unpackTemp(t, archive, Limits{MaxEntries: 3})
+	if err != ErrEntries {
+		t.Fatalf("Unpack error = %v, want %v", err, ErrEntries)
+	}
+	if len(entries) != 3 {
+		t.Errorf("Unpack returned %d entries, want the 3 unpacked before the limit", len(entries))
+	}
+
+	_, entries, err = unpackTemp(t, archive, Limits{MaxEntries: 5})
+	if err != nil || len(entries) != 5 {
+		t.Errorf("Unpack with room for every entry = %d entries, %v", len(entries), err)
+	}
+}
+
+func TestMaxRatio(t *testing.T) {
+
+	//Zeros compress to almost nothing, far past any sane ratio once larger than the minimum every archive can unpack
+	bomb := zipOf(t, file{"zeros", make([]byte, 4*minRatioSize)})
+
+	out, entries, err := unpackTemp(t, bomb, Limits{MaxRatio: 10})
+	if err != ErrRatio {
+		t.Fatalf("Unpack error = %v, want %v", err, ErrRatio)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Unpack returned %v, want no entries", names(entries))
+	}
+
+	//The partly written file is removed
+	left, _ := ioutil.ReadDir(out)
+	if len(left) != 0 {
+		t.Errorf("unpack dir holds %d files after the limit was hit, want 0", len(left))
+	}
+
+	//Small files are always unpacked no matter their ratio
+	small := zipOf(t, file{"zeros", make([]byte, minRatioSize/2)})
+	if _, entries, err := unpackTemp(t, small, Limits{MaxRatio: 10}); err != nil || len(entries) != 1 {
+		t.Errorf("Unpack of a small archive = %d entries, %v", len(entries), err)
+	}
+}
+
+func TestMaxTotalSize(t *testing.T) {
+
+	archive := zipOf(t, file{"first", make([]byte, 600*1024)}, file{"second", make([]byte, 600*1024)})
+
+	_, entries, err := unpackTemp(t, archive, Limits{MaxTotalSize: 1024 * 1024, MaxRatio: 1e9})
+	if err != ErrTotalSize {
+		t.Fatalf("Unpack error = %v, want %v", err, ErrTotalSize)
+	}
+	if got := strings.Join(names(entries), ","); got != "first" {
+		t.Errorf("Unpack returned %s, want first", got)
+	}
+}
+
+func TestCleanName(t *testing.T) {
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"sample.exe", "sample.exe"},
+		{"dir/sample.exe", "sample.exe"},
+		{"../../etc/cron.d/evil", "evil"},
+		{"/etc/passwd", "passwd"},
+		{`..\..\windows\system32\evil.dll`, "evil.dll"},
+		{"dir/", "dir"},
+		{"..", "file"},
+		{".", "file"},
+		{"", "file"},
+		{"/", "file"},
+	}
+
+	for _, test := range tests {
+		if got := cleanName(test.name); got != test.want {
+			t.Errorf("cleanName(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestTraversal(t *testing.T) {
+
+	archive := zipOf(t, file{"../../../evil.sh", []byte("malscan")}, file{`..\..\evil.bat`, []byte("malscan")})
+
+	out, entries, err := unpackTemp(t, archive, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Unpack returned %d entries, want 2", len(entries))
+	}
+
+	for _, entry := range entries {
+		rel, err := filepath.Rel(out, entry.Path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			t.Errorf("%s was written outside the unpack dir to %s", entry.Name, entry.Path)
+		}
+		if _, err := os.Stat(entry.Path); err != nil {
+			t.Errorf("%s: %v", entry.Name, err)
+		}
+	}
+
+	if entries[0].Name != "evil.sh" {
+		t.Errorf("name of the first entry = %q, want evil.sh", entries[0].Name)
+	}
+}
@@ -53,6 +53,42 @@ func GetFilestoreDir() string {
 	return filepath.Join(GetBaseDir(), "filestore")
 }
 
+//GetScratchDir - helper function to get scratch dir, used for short lived per scan files
+func GetScratchDir() string {
+
+	return filepath.Join(GetBaseDir(), "scratch")
+}
+
+//GetUploadsDir - helper function to get uploads dir, used for samples submitted through the api
+func GetUploadsDir() string {
+
+	return filepath.Join(GetBaseDir(), "uploads")
+}
+
+//GetQuarantineDir - helper function to get quarantine dir, used for quarantined files unless another dir is configured
+func GetQuarantineDir() string {
+
+	return filepath.Join(GetBaseDir(), "quarantine")
+}
+
+//GetJournalDir - helper function to get journal dir, used for the scan queue journal
+func GetJournalDir() string {
+
+	return filepath.Join(GetBaseDir(), "journal")
+}
+
+//GetCacheDir - helper function to get cache dir, used for cached reports
+func GetCacheDir() string {
+
+	return filepath.Join(GetBaseDir(), "cache")
+}
+
+//GetRunDir - helper function to get run dir, used for the leases running malscans hold
+func GetRunDir() string {
+
+	return filepath.Join(GetBaseDir(), "run")
+}
+
 //MakeDirs - Responsible for creating malscan dirs is they don't exist already
 func MakeDirs() {
 
@@ -78,4 +114,28 @@ func MakeDirs() {
 		os.MkdirAll(GetFilestoreDir(), 0777)
 		log.Debug("creating filestore directory for malscan")
 	}
+	if _, err := os.Stat(GetScratchDir()); os.IsNotExist(err) {
+		os.MkdirAll(GetScratchDir(), 0777)
+		log.Debug("creating scratch directory for malscan")
+	}
+	if _, err := os.Stat(GetUploadsDir()); os.IsNotExist(err) {
+		os.MkdirAll(GetUploadsDir(), 0777)
+		log.Debug("creating uploads directory for malscan")
+	}
+	if _, err := os.Stat(GetQuarantineDir()); os.IsNotExist(err) {
+		os.MkdirAll(GetQuarantineDir(), 0700)
+		log.Debug("creating quarantine directory for malscan")
+	}
+	if _, err := os.Stat(GetJournalDir()); os.IsNotExist(err) {
+		os.MkdirAll(GetJournalDir(), 0777)
+		log.Debug("creating journal directory for malscan")
+	}
+	if _, err := os.Stat(GetCacheDir()); os.IsNotExist(err) {
+		os.MkdirAll(GetCacheDir(), 0777)
+		log.Debug("creating cache directory for malscan")
+	}
+	if _, err := os.Stat(GetRunDir()); os.IsNotExist(err) {
+		os.MkdirAll(GetRunDir(), 0777)
+		log.Debug("creating run directory for malscan")
+	}
 }
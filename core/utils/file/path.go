@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"path/filepath"
+
+	"malscan/config"
+	"malscan/core/utils"
+)
+
+//Filestore - Returns the filestore dir set in the malscan config or the default filestore dir if it has not been set
+func Filestore() string {
+
+	if config.Values.Env.Filestore == "" {
+		return utils.GetFilestoreDir() //If filestore has not be set in the config file then use default filestore
+	}
+
+	return config.Values.Env.Filestore //If filestore is set use the user provided path in config file
+}
+
+//Path - Resolves a filename to a path on disk, absolute paths are returned as is
+//and anything else is treated as a file inside of the filestore
+func Path(filename string) string {
+
+	if filepath.IsAbs(filename) {
+		return filename
+	}
+
+	return filepath.Join(Filestore(), filename)
+}
@@ -7,24 +7,14 @@ import (
 	"encoding/hex"
 	"io"
 	"os"
-	"path/filepath"
 
-	"malscan/config"
-	"malscan/core/utils"
+	mfile "malscan/core/utils/file"
 )
 
-//GenerateFileSha256 - accepts file in filestore dir and returns shar256
+//GenerateFileSha256 - accepts file in filestore dir (or an absolute path) and returns shar256
 func GenerateFileSha256(filename *string) (result string, err error) {
 
-	var filePath string
-
-	if config.Values.Env.Filestore == "" {
-		filePath = filepath.Join(utils.GetFilestoreDir(), *filename)
-	} else {
-		filePath = filepath.Join(config.Values.Env.Filestore, *filename)
-	}
-
-	file, err := os.Open(filePath)
+	file, err := os.Open(mfile.Path(*filename))
 	if err != nil {
 		return
 	}
@@ -40,18 +30,10 @@ func GenerateFileSha256(filename *string) (result string, err error) {
 	return
 }
 
-//GenerateFileMd5 - accepts file in filestore dir and returns md5
+//GenerateFileMd5 - accepts file in filestore dir (or an absolute path) and returns md5
 func GenerateFileMd5(filename *string) (result string, err error) {
 
-	var filePath string
-
-	if config.Values.Env.Filestore == "" {
-		filePath = filepath.Join(utils.GetFilestoreDir(), *filename)
-	} else {
-		filePath = filepath.Join(config.Values.Env.Filestore, *filename)
-	}
-
-	file, err := os.Open(filePath)
+	file, err := os.Open(mfile.Path(*filename))
 	if err != nil {
 		return
 	}
@@ -67,18 +49,10 @@ func GenerateFileMd5(filename *string) (result string, err error) {
 	return
 }
 
-//GenerateFileSha1 - accepts file in filestore dir and returns shar1
+//GenerateFileSha1 - accepts file in filestore dir (or an absolute path) and returns shar1
 func GenerateFileSha1(filename *string) (result string, err error) {
 
-	var filePath string
-
-	if config.Values.Env.Filestore == "" {
-		filePath = filepath.Join(utils.GetFilestoreDir(), *filename)
-	} else {
-		filePath = filepath.Join(config.Values.Env.Filestore, *filename)
-	}
-
-	file, err := os.Open(filePath)
+	file, err := os.Open(mfile.Path(*filename))
 	if err != nil {
 		return
 	}
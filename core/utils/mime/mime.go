@@ -1,30 +1,21 @@
 package utils
 
 import (
-	"malscan/config"
-	"malscan/core/utils"
-	"path/filepath"
+	file "malscan/core/utils/file"
 
 	"github.com/gabriel-vasile/mimetype"
 	log "github.com/sirupsen/logrus"
 )
 
+//FileType - Detects the mime type of a file, filenames are resolved against the filestore unless absolute
+//An empty string is returned if the type could not be detected
 func FileType(filename string) (ftype string) {
 
-	var fileDirOnDisk string
-
-	if config.Values.Env.Filestore == "" {
-		fileDirOnDisk = utils.GetFilestoreDir() //If filestore has not be set in the config file then use default filestore
-	} else {
-		fileDirOnDisk = config.Values.Env.Filestore //If filestore is set use the user provided path in config file
-	}
-
-	file := filepath.Join(fileDirOnDisk, filename)
-
-	mime, err := mimetype.DetectFile(file)
+	mime, err := mimetype.DetectFile(file.Path(filename))
 
 	if err != nil {
 		log.WithFields(log.Fields{"err": err}).Error("failed to detect file type")
+		return ""
 	}
 
 	return mime.String()
@@ -1,16 +1,42 @@
 package utils
 
-import "regexp"
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
-//ParseInstance - Helper function to extract pandora instance from filename
-func ParseInstance(toParse string) (rs string) {
+	"github.com/pkg/errors"
+)
+
+//instanceRgx - Matches the instance a file came from, written in brackets in its name
+var instanceRgx = regexp.MustCompile(`\((.*?)\)`)
 
-	var rgx = regexp.MustCompile(`\((.*?)\)`)
+//ParseInstance - Helper function to extract pandora instance from filename, an empty string is returned when the filename has none.
+//Filenames come from uploads and scanned paths so they can not be trusted to contain one
+func ParseInstance(toParse string) (rs string) {
 
-	rss := rgx.FindStringSubmatch(toParse)
+	rss := instanceRgx.FindStringSubmatch(toParse)
+	if len(rss) < 2 {
+		return ""
+	}
 
 	rs = rss[1]
 
 	return
 
 }
+
+//ParseAge - Helper function to parse an age such as "30d" or "12h", days are accepted on top of the units time.ParseDuration understands
+func ParseAge(age string) (time.Duration, error) {
+
+	if strings.HasSuffix(age, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(age, "d"))
+		if err != nil {
+			return 0, errors.New("invalid age: " + age)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(age)
+}
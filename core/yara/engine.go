@@ -0,0 +1,187 @@
+//go:build yara
+// +build yara
+
+package yara
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	goyara "github.com/hillu/go-yara/v4"
+	"github.com/pkg/errors"
+	"github.com/radovskyb/watcher"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains the in-process yara engine
+
+*/
+
+//Engine - Compiled yara rules from a set of rule directories, the rules are recompiled when the directories change
+type Engine struct {
+	dirs  []string
+	mu    sync.RWMutex
+	rules *goyara.Rules
+}
+
+//NewEngine - Responsible for compiling every .yar and .yara file found under the directories passed in
+//and recompiling them whenever a rule file is added, changed or removed
+func NewEngine(dirs []string) (*Engine, error) {
+
+	engine := &Engine{dirs: dirs}
+
+	if err := engine.compile(); err != nil {
+		return nil, err
+	}
+
+	if err := engine.watch(); err != nil {
+		return nil, err
+	}
+
+	return engine, nil
+}
+
+//compile - Compiles the rule directories, the rules in use are only replaced if every rule file compiled
+func (engine *Engine) compile() error {
+
+	log.Debugf("compiling yara rules from:%s", strings.Join(engine.dirs, ","))
+
+	compiler, err := goyara.NewCompiler()
+	if err != nil {
+		return errors.Wrap(err, "error while creating yara compiler")
+	}
+	defer compiler.Destroy()
+
+	count := 0
+
+	for _, dir := range engine.dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || !isRuleFile(info.Name()) {
+				return nil
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			//Each file gets its own namespace so rules with the same name in different files do not collide
+			namespace, _ := filepath.Rel(dir, path)
+			if err := compiler.AddFile(f, namespace); err != nil {
+				return errors.Wrap(err, "error while compiling: "+path)
+			}
+			count++
+			return nil
+		})
+		if err != nil {
+			for _, msg := range compiler.Errors {
+				log.Errorf("yara:%s:%d:%s", msg.Filename, msg.Line, msg.Text)
+			}
+			return errors.Wrap(err, "error while compiling yara rules in: "+dir)
+		}
+	}
+
+	rules, err := compiler.GetRules()
+	if err != nil {
+		return errors.Wrap(err, "error while getting compiled yara rules")
+	}
+
+	engine.mu.Lock()
+	old := engine.rules
+	engine.rules = rules
+	engine.mu.Unlock()
+
+	if old != nil {
+		old.Destroy()
+	}
+
+	log.Infof("compiled:%d:yara rule files", count)
+
+	return nil
+}
+
+//watch - Recompiles the rules whenever a file in one of the rule directories changes
+func (engine *Engine) watch() error {
+
+	w := watcher.New()
+	w.FilterOps(watcher.Create, watcher.Write, watcher.Remove, watcher.Rename, watcher.Move)
+
+	for _, dir := range engine.dirs {
+		if err := w.AddRecursive(dir); err != nil {
+			return errors.Wrap(err, "error while watching yara rule directory: "+dir)
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case event := <-w.Event:
+				log.Debugf("yara rules changed:%s", event.Path)
+				if err := engine.compile(); err != nil {
+					log.WithFields(log.Fields{"err": err}).Error("recompiling yara rules, keeping the previous rules")
+				}
+			case err := <-w.Error:
+				log.Error(err)
+			case <-w.Closed:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		if err := w.Start(time.Second * 5); err != nil {
+			log.Error(errors.Wrap(err, "error while starting yara rule watcher"))
+		}
+	}()
+
+	return nil
+}
+
+//ScanFile - Scans a file with the compiled rules, timeout is rounded down to seconds by libyara
+func (engine *Engine) ScanFile(path string, timeout time.Duration) ([]Match, error) {
+
+	engine.mu.RLock()
+	defer engine.mu.RUnlock()
+
+	var matches goyara.MatchRules
+
+	if err := engine.rules.ScanFile(path, 0, timeout, &matches); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error while scanning %s with yara", path))
+	}
+
+	results := make([]Match, 0, len(matches))
+
+	for _, m := range matches {
+
+		match := Match{
+			Rule:      m.Rule,
+			Namespace: m.Namespace,
+			Tags:      m.Tags,
+			Meta:      make(map[string]interface{}),
+		}
+
+		for _, meta := range m.Metas {
+			match.Meta[meta.Identifier] = meta.Value
+		}
+
+		for _, s := range m.Strings {
+			match.Strings = append(match.Strings, MatchString{Name: s.Name, Offset: s.Base + s.Offset, Length: len(s.Data)})
+		}
+
+		results = append(results, match)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,41 @@
+package yara
+
+import (
+	"errors"
+	"path/filepath"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains the structs used to report yara matches
+
+*/
+
+//ErrUnsupported - Returned when malscan was built without yara support
+var ErrUnsupported = errors.New("malscan was built without yara support, rebuild with -tags yara")
+
+//Match - A rule that matched a file
+type Match struct {
+	Rule      string                 `json:"rule"`
+	Namespace string                 `json:"namespace"`
+	Tags      []string               `json:"tags"`
+	Meta      map[string]interface{} `json:"meta"`
+	Strings   []MatchString          `json:"strings"`
+}
+
+//MatchString - A string from a rule that matched a file
+type MatchString struct {
+	Name   string `json:"name"`
+	Offset uint64 `json:"offset"`
+	Length int    `json:"length"`
+}
+
+//isRuleFile - Tests whether a file in a rule directory should be compiled
+func isRuleFile(name string) bool {
+
+	ext := filepath.Ext(name)
+
+	return ext == ".yar" || ext == ".yara"
+}
@@ -0,0 +1,29 @@
+//go:build !yara
+// +build !yara
+
+package yara
+
+import "time"
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Stands in for the yara engine when malscan is built without libyara
+
+*/
+
+//Engine - Stand in for the yara engine, malscan must be built with -tags yara to scan with yara in-process
+type Engine struct{}
+
+//NewEngine - Always returns ErrUnsupported
+func NewEngine(dirs []string) (*Engine, error) {
+
+	return nil, ErrUnsupported
+}
+
+//ScanFile - Always returns ErrUnsupported
+func (engine *Engine) ScanFile(path string, timeout time.Duration) ([]Match, error) {
+
+	return nil, ErrUnsupported
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"malscan/config"
+	"malscan/core/api"
+	"malscan/core/cache"
+	"malscan/core/icap"
 	mlog "malscan/core/logger"
+	"malscan/core/quarantine"
 	"malscan/core/scan"
 	"malscan/core/utils"
+	pconfig "malscan/plugins"
 	"malscan/system"
 
 	log "github.com/sirupsen/logrus"
@@ -62,6 +68,285 @@ func main() {
 				return nil
 			},
 		},
+		{
+			Name:      "scan",
+			Aliases:   []string{"s"},
+			Usage:     "scans the files and directories passed in once, prints a report for each file and exits",
+			ArgsUsage: "<path>...",
+			Action: func(c *cli.Context) error {
+				if c.NArg() == 0 {
+					return cli.NewExitError("no files or directories to scan", 1)
+				}
+				return scan.Paths(c.Args())
+			},
+		},
+		{
+			Name:  "serve",
+			Usage: "starts the http api for submitting samples and retrieving reports",
+			Action: func(c *cli.Context) error {
+				return api.Serve()
+			},
+		},
+		{
+			Name:  "quarantine",
+			Usage: "manages the quarantine store of scanned files",
+			Subcommands: []cli.Command{
+				{
+					Name:  "list",
+					Usage: "lists every quarantined file",
+					Action: func(c *cli.Context) error {
+						return quarantine.PrintList()
+					},
+				},
+				{
+					Name:      "restore",
+					Usage:     "unwraps a quarantined file to dest, a directory restores the file under its original name",
+					ArgsUsage: "<sha256> <dest>",
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 2 {
+							return cli.NewExitError("restore needs a sha256 and a destination", 1)
+						}
+						restored, err := quarantine.Restore(c.Args().Get(0), c.Args().Get(1))
+						if err != nil {
+							return cli.NewExitError(err.Error(), 1)
+						}
+						fmt.Println(restored)
+						return nil
+					},
+				},
+				{
+					Name:  "purge",
+					Usage: "removes quarantined files, without --older-than the retention settings from the malscan config are applied",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "older-than",
+							Usage: "remove files quarantined longer ago than this, for example 30d or 12h",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						var purged int
+						var err error
+						if c.String("older-than") != "" {
+							age, ageErr := utils.ParseAge(c.String("older-than"))
+							if ageErr != nil {
+								return cli.NewExitError(ageErr.Error(), 1)
+							}
+							purged, err = quarantine.Purge(age)
+						} else {
+							purged, err = quarantine.Enforce()
+						}
+						fmt.Printf("purged %d quarantined files\n", purged)
+						if err != nil {
+							return cli.NewExitError(err.Error(), 1)
+						}
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "cache",
+			Usage: "manages the cache of reports for files that were already scanned",
+			Subcommands: []cli.Command{
+				{
+					Name:  "stats",
+					Usage: "prints a summary of the cached reports",
+					Action: func(c *cli.Context) error {
+						return cache.PrintStats()
+					},
+				},
+				{
+					Name:  "clear",
+					Usage: "removes every cached report",
+					Action: func(c *cli.Context) error {
+						cleared, err := cache.Clear()
+						fmt.Printf("cleared %d cached reports\n", cleared)
+						if err != nil {
+							return cli.NewExitError(err.Error(), 1)
+						}
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:      "update",
+			Usage:     "updates every enabled updatable plugin, or only the plugin named, rolling back updates that fail their self-test",
+			ArgsUsage: "[plugin]",
+			Action: func(c *cli.Context) error {
+				//Only the daemon can pause its own scans while a plugin is updated
+				if pconfig.DaemonRunning() {
+					return cli.NewExitError("malscan is running as a daemon (watch, serve or icap), stop it first or let it update plugins on the schedule in the updates section of the malscan config", 1)
+				}
+				plugins := pconfig.PluginConfig{}
+				plugins = plugins.Load()
+				if c.NArg() > 0 {
+					fmt.Println(plugins.RunPluginUpdate(c.Args().First()))
+					return nil
+				}
+				for _, statuses := range plugins.RunPluginUpdateAll() {
+					if len(statuses) == 0 {
+						fmt.Println("no enabled plugins can be updated")
+					}
+					for name, status := range statuses {
+						fmt.Printf("%s Update: %s\n", name, status)
+					}
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "plugins",
+			Usage: "manages the plugins in plugins.toml, running malscans pick up changes when they are restarted",
+			Subcommands: []cli.Command{
+				{
+					Name:  "list",
+					Usage: "lists every plugin along with whether it is installed, its image digest and when it was last updated",
+					Action: func(c *cli.Context) error {
+						plugins := pconfig.PluginConfig{}
+						return plugins.Load().PrintList()
+					},
+				},
+				{
+					Name:      "info",
+					Usage:     "prints a plugins settings and install status",
+					ArgsUsage: "<name>",
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							return cli.NewExitError("info needs the name of a plugin", 1)
+						}
+						plugins := pconfig.PluginConfig{}
+						if err := plugins.Load().PrintInfo(c.Args().First()); err != nil {
+							return cli.NewExitError(err.Error(), 1)
+						}
+						return nil
+					},
+				},
+				{
+					Name:      "enable",
+					Usage:     "enables a plugin",
+					ArgsUsage: "<name>",
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							return cli.NewExitError("enable needs the name of a plugin", 1)
+						}
+						plugins := pconfig.PluginConfig{}
+						plugins = plugins.Load()
+						if err := plugins.EnablePlugin(c.Args().First()); err != nil {
+							return cli.NewExitError(err.Error(), 1)
+						}
+						fmt.Printf("enabled %s\n", c.Args().First())
+						return nil
+					},
+				},
+				{
+					Name:      "disable",
+					Usage:     "disables a plugin",
+					ArgsUsage: "<name>",
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							return cli.NewExitError("disable needs the name of a plugin", 1)
+						}
+						plugins := pconfig.PluginConfig{}
+						plugins = plugins.Load()
+						if err := plugins.DisablePlugin(c.Args().First()); err != nil {
+							return cli.NewExitError(err.Error(), 1)
+						}
+						fmt.Printf("disabled %s\n", c.Args().First())
+						return nil
+					},
+				},
+				{
+					Name:      "add",
+					Usage:     "adds a plugin to the end of plugins.toml",
+					ArgsUsage: "<name>",
+					Flags: []cli.Flag{
+						cli.StringFlag{Name: "category", Value: "av", Usage: "\"av\" or \"er\""},
+						cli.StringFlag{Name: "runtime", Value: "docker", Usage: "\"docker\", \"exec\", \"yara\" or \"clamd\""},
+						cli.StringFlag{Name: "image", Usage: "image ran by docker plugins"},
+						cli.StringFlag{Name: "command", Usage: "binary or script ran by exec plugins"},
+						cli.StringSliceFlag{Name: "arg", Usage: "argument passed to the command of exec plugins, can be repeated"},
+						cli.StringSliceFlag{Name: "rules", Usage: "directory of rules for yara plugins, can be repeated"},
+						cli.StringFlag{Name: "address", Usage: "address of the clamd for clamd plugins"},
+						cli.StringFlag{Name: "description", Usage: "description of the plugin"},
+						cli.StringFlag{Name: "mime", Value: "*", Usage: "mime types the plugin is ran against"},
+						cli.StringFlag{Name: "timeout", Value: "5m", Usage: "how long the plugin can run against a file"},
+						cli.BoolFlag{Name: "updatable", Usage: "the plugin can be updated"},
+						cli.BoolFlag{Name: "disabled", Usage: "add the plugin without enabling it"},
+					},
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							return cli.NewExitError("add needs the name of the plugin", 1)
+						}
+						plugin := pconfig.Plugin{
+							Enabled:     !c.Bool("disabled"),
+							Name:        c.Args().First(),
+							Description: c.String("description"),
+							Category:    c.String("category"),
+							Image:       c.String("image"),
+							Updatable:   c.Bool("updatable"),
+							Mime:        c.String("mime"),
+							Runtime:     c.String("runtime"),
+							Command:     c.String("command"),
+							Args:        c.StringSlice("arg"),
+							Rules:       c.StringSlice("rules"),
+							Address:     c.String("address"),
+							Timeout:     c.String("timeout"),
+						}
+						plugins := pconfig.PluginConfig{}
+						plugins = plugins.Load()
+						if err := plugins.AddPlugin(plugin); err != nil {
+							return cli.NewExitError(err.Error(), 1)
+						}
+						fmt.Printf("added %s\n", plugin.Name)
+						return nil
+					},
+				},
+				{
+					Name:      "remove",
+					Usage:     "removes a plugin from plugins.toml",
+					ArgsUsage: "<name>",
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							return cli.NewExitError("remove needs the name of a plugin", 1)
+						}
+						plugins := pconfig.PluginConfig{}
+						plugins = plugins.Load()
+						if err := plugins.RemovePlugin(c.Args().First()); err != nil {
+							return cli.NewExitError(err.Error(), 1)
+						}
+						fmt.Printf("removed %s\n", c.Args().First())
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "containers",
+			Usage: "manages the containers malscan creates to run plugins",
+			Subcommands: []cli.Command{
+				{
+					Name:  "gc",
+					Usage: "removes containers that outlived their plugins timeout or the malscan that created them",
+					Action: func(c *cli.Context) error {
+						plugins := pconfig.PluginConfig{}
+						removed, err := plugins.Load().GCContainers()
+						fmt.Printf("removed %d containers\n", removed)
+						if err != nil {
+							return cli.NewExitError(err.Error(), 1)
+						}
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "icap",
+			Usage: "starts the icap server used by proxies and gateways to scan content",
+			Action: func(c *cli.Context) error {
+				return icap.Serve()
+			},
+		},
 	}
 	system.SetCPUCores()
 	utils.MakeDirs()
@@ -0,0 +1,121 @@
+package plugins
+
+import (
+	"sync"
+	"time"
+
+	"malscan/core/cache"
+	"malscan/structs"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains functions related to reusing cached reports
+
+*/
+
+//signaturesTTL - How long plugin signatures are reused before they are looked up again, so each file does not inspect every image
+const signaturesTTL = time.Minute
+
+//policyKey - Key the verdict policy is stored under alongside the plugin signatures, changing the policy invalidates the cache
+const policyKey = "verdict-policy"
+
+var signatureMutex sync.Mutex
+var pluginSignatures map[string]string //Signature of every enabled plugin, nil when a signature could not be looked up
+var signaturesChecked time.Time
+
+//signatures - Returns the signature of every enabled plugin along with the verdict policy, nil is returned if the cache is
+//disabled or any signature could not be looked up as a cached report could not be checked against it
+func (pconfig PluginConfig) signatures(policy verdictPolicy) map[string]string {
+
+	if !cache.Enabled() {
+		return nil
+	}
+
+	signatureMutex.Lock()
+	defer signatureMutex.Unlock()
+
+	if time.Since(signaturesChecked) > signaturesTTL {
+
+		signaturesChecked = time.Now()
+		pluginSignatures = make(map[string]string)
+
+		for _, plugin := range pconfig.GetEnabledPlugins() {
+			signature, err := plugin.runtime().Signature(plugin)
+			if err != nil {
+				log.WithFields(log.Fields{"err": err}).Debugf("no signature for plugin:%s:not using the cache", plugin.Name)
+				pluginSignatures = nil
+				break
+			}
+			pluginSignatures[plugin.Name] = signature
+		}
+	}
+
+	if pluginSignatures == nil {
+		return nil
+	}
+
+	signatures := map[string]string{policyKey: policy.String()}
+	for name, signature := range pluginSignatures {
+		signatures[name] = signature
+	}
+
+	return signatures
+}
+
+//resetSignatures - Forces the plugin signatures to be looked up again, used once plugins have been updated
+func resetSignatures() {
+
+	signatureMutex.Lock()
+	defer signatureMutex.Unlock()
+
+	signaturesChecked = time.Time{}
+}
+
+//CachedReport - Returns the cached report for a sha256 if it was scanned with the signatures and verdict policy in use now,
+//the report is returned as it was cached without raising alerts again
+func (pconfig PluginConfig) CachedReport(sha256 string) (structs.FullFileReport, bool) {
+
+	return cache.Get(sha256, pconfig.signatures(loadVerdictPolicy()))
+}
+
+//cacheable - Only reports where every plugin ran (or was skipped) are cached so a plugin failing is not remembered
+func cacheable(fileReport structs.FullFileReport) bool {
+
+	for _, status := range fileReport.File.Malware.Analyzers.Status {
+		if status.State != structs.StateOK && status.State != structs.StateSkipped {
+			return false
+		}
+	}
+
+	return true
+}
+
+//fromCache - Turns a cached report into the report for this file, the original scan time is kept and alerts are raised as if the
+//file had been scanned
+func fromCache(cached structs.FullFileReport, name string, alertAs string, policy verdictPolicy) structs.FullFileReport {
+
+	fileReport := cached
+
+	fileReport.File.Name = name
+	fileReport.File.FromCache = true
+	if fileReport.File.ScannedAt == "" {
+		fileReport.File.ScannedAt = cached.File.Date
+	}
+	fileReport.File.Date = time.Now().Format(time.RFC3339)
+
+	detectionOutput := make(map[string][]byte)
+	for _, plugName := range fileReport.File.Malware.Analyzers.Names {
+		detectionOutput[plugName] = []byte(fileReport.File.Malware.Analyzers.RawAnalysis.AntiVirus[plugName])
+	}
+
+	raiseAlert(fileReport, detectionOutput, policy, alertAs)
+
+	log.Infof("analyzed:%s:from cache:scanned at:%s:verdict:%s", alertAs, fileReport.File.ScannedAt, fileReport.File.Malware.Verdict)
+
+	return fileReport
+}
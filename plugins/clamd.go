@@ -0,0 +1,157 @@
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"malscan/core/clamd"
+	file "malscan/core/utils/file"
+	"malscan/structs"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains the clamd runtime, files are streamed to a running clamd instead of starting a clamav container per file
+
+*/
+
+const (
+	runtimeClamd = "clamd"
+)
+
+//clamdOutput - The analysis json written by the clamd runtime, the same contract container plugins follow
+type clamdOutput struct {
+	Analysis struct {
+		Infected        bool   `json:"infected"`
+		Result          string `json:"result"`
+		Engine          string `json:"engine"`
+		DatabaseVersion string `json:"database_version"`
+		DatabaseDate    string `json:"database_date"`
+		structs.PluginVersion
+	} `json:"analysis"`
+}
+
+//clamdRuntime - Streams files to the clamd set in the plugins address
+type clamdRuntime struct{}
+
+//client - Returns a clamd client for the plugin
+func (clamdRuntime) client(plugin Plugin) (*clamd.Client, error) {
+
+	timeout, _ := plugin.timeout() //Timeouts are validated when plugins are loaded
+
+	return clamd.NewClient(plugin.Address, timeout)
+}
+
+//Installed - Returns the plugins whose clamd answers a PING
+func (runtime clamdRuntime) Installed(plugins []Plugin) (installed []Plugin) {
+
+	for _, plugin := range plugins {
+
+		client, err := runtime.client(plugin)
+		if err == nil {
+			err = client.Ping()
+		}
+		if err != nil {
+			log.WithFields(log.Fields{"err": err}).Errorf("clamd plugin:%s:is unavailable", plugin.Name)
+			continue
+		}
+
+		installed = append(installed, plugin)
+	}
+
+	return installed
+}
+
+//Run - Streams the file to clamd, the engine and database version are recorded alongside the result
+//A stream that has started is not interrupted by ctx as it is bounded by the plugins timeout
+func (runtime clamdRuntime) Run(ctx context.Context, plugin Plugin, filename *string) (Output, error) {
+
+	if ctx.Err() != nil {
+		return Output{ExitCode: -1}, ErrCancelled
+	}
+
+	client, err := runtime.client(plugin)
+	if err != nil {
+		return Output{ExitCode: -1}, err
+	}
+
+	version, err := client.Version()
+	if err != nil {
+		return Output{ExitCode: -1}, err
+	}
+
+	f, err := os.Open(file.Path(*filename))
+	if err != nil {
+		return Output{ExitCode: -1}, errors.Wrap(err, "error while opening file for clamd")
+	}
+	defer f.Close()
+
+	result, err := client.InStream(f)
+	if err != nil {
+		return Output{ExitCode: -1}, err
+	}
+
+	var output clamdOutput
+
+	output.Analysis.Infected = result.Infected
+	output.Analysis.Result = result.Signature
+	output.Analysis.Engine = version.Engine
+	output.Analysis.DatabaseVersion = version.Database
+	output.Analysis.DatabaseDate = version.DatabaseDate
+	output.Analysis.PluginVersion = clamdVersion(version)
+
+	stdout, err := json.Marshal(output)
+	if err != nil {
+		return Output{ExitCode: -1}, errors.Wrap(err, "error while marshaling clamd result")
+	}
+
+	return Output{Stdout: stdout}, nil
+}
+
+//Signature - Returns the engine and database version clamd reports, these change when freshclam updates the database
+func (runtime clamdRuntime) Signature(plugin Plugin) (string, error) {
+
+	client, err := runtime.client(plugin)
+	if err != nil {
+		return "", err
+	}
+
+	version, err := client.Version()
+	if err != nil {
+		return "", err
+	}
+
+	return version.Engine + "/" + version.Database + "/" + version.DatabaseDate, nil
+}
+
+//Version - Returns the engine and database version clamd reports
+func (runtime clamdRuntime) Version(plugin Plugin) (structs.PluginVersion, error) {
+
+	client, err := runtime.client(plugin)
+	if err != nil {
+		return structs.PluginVersion{}, err
+	}
+
+	version, err := client.Version()
+	if err != nil {
+		return structs.PluginVersion{}, err
+	}
+
+	return clamdVersion(version), nil
+}
+
+//clamdVersion - Maps the version clamd reports onto the version contract plugins follow
+func clamdVersion(version clamd.Version) structs.PluginVersion {
+
+	return structs.PluginVersion{
+		EngineVersion:    version.Engine,
+		SignatureVersion: version.Database,
+		SignatureDate:    version.DatabaseDate,
+	}
+}
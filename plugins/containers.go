@@ -0,0 +1,149 @@
+package plugins
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	malscanconfig "malscan/config"
+	"malscan/core/docker"
+	"malscan/core/utils"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains functions related to reaping the containers plugins leave behind
+
+*/
+
+const (
+	defaultReapInterval = time.Minute
+	reapGrace           = time.Minute //Time a container gets past its plugins timeout to be killed and removed by the scan that created it
+)
+
+//scanIDKey - Context key the id of the scan being ran is stored under
+type scanIDKey struct{}
+
+//withScanID - Returns a context carrying a new scan id, containers ran with the context are labelled with the id
+func withScanID(ctx context.Context) context.Context {
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("generating scan id")
+		return ctx
+	}
+
+	return context.WithValue(ctx, scanIDKey{}, hex.EncodeToString(b))
+}
+
+//scanID - Returns the scan id carried by the context, empty when there is none
+func scanID(ctx context.Context) string {
+
+	id, _ := ctx.Value(scanIDKey{}).(string)
+	return id
+}
+
+//GCContainers - Responsible for removing the containers of this malscan instance that nothing else will remove,
+//containers whose malscan is no longer running and containers that outlived their plugins timeout or update timeout
+func (pconfig PluginConfig) GCContainers() (removed int, err error) {
+
+	containers, err := docker.ListContainers()
+	if err != nil {
+		return 0, err
+	}
+
+	timeouts := make(map[string]time.Duration)
+	updateTimeouts := make(map[string]time.Duration)
+	for _, plugin := range pconfig.Plugins {
+		timeouts[plugin.Name], _ = plugin.timeout()
+		updateTimeouts[plugin.Name], _ = plugin.updateTimeout()
+	}
+
+	defer docker.SweepLeases()
+
+	for _, container := range containers {
+
+		//Scan containers are labelled with their scan, update containers are not
+		timeout := timeouts[container.Plugin]
+		if container.ScanID == "" {
+			timeout = updateTimeouts[container.Plugin]
+		}
+
+		var reason string
+		switch {
+		case container.Orphaned():
+			reason = "its malscan is no longer running"
+		case timeout > 0 && time.Since(container.Started) > timeout+reapGrace:
+			reason = "it outlived its plugins timeout"
+		default:
+			continue
+		}
+
+		if err := docker.RemoveContainer(container.ID); err != nil {
+			log.WithFields(log.Fields{"err": err}).Errorf("removing container:%s:of plugin:%s", container.ID, container.Plugin)
+			continue
+		}
+
+		log.Infof("removed container:%s:of plugin:%s:as %s", container.ID, container.Plugin, reason)
+		removed++
+	}
+
+	return removed, nil
+}
+
+//StartContainerReaper - Responsible for removing leftover containers on startup and again every reap_interval,
+//nothing is done when no enabled plugin is ran with docker. The function returned stops the reaper
+func (pconfig PluginConfig) StartContainerReaper() (stop func()) {
+
+	usesDocker := false
+	for _, plugin := range pconfig.GetEnabledPlugins() {
+		if plugin.runtimeName() == runtimeDocker {
+			usesDocker = true
+			break
+		}
+	}
+	if !usesDocker {
+		return func() {}
+	}
+
+	interval := defaultReapInterval
+	if malscanconfig.Values.Sandbox.ReapInterval != "" {
+		parsed, err := utils.ParseAge(malscanconfig.Values.Sandbox.ReapInterval)
+		if err != nil || parsed <= 0 {
+			log.WithFields(log.Fields{"err": err}).Errorf("invalid reap_interval, using:%s", defaultReapInterval)
+		} else {
+			interval = parsed
+		}
+	}
+
+	reap := func() {
+		if _, err := pconfig.GCContainers(); err != nil {
+			log.WithFields(log.Fields{"err": err}).Error("reaping containers")
+		}
+	}
+
+	reap()
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				reap()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
@@ -0,0 +1,258 @@
+package plugins
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"malscan/core/utils"
+
+	toml "github.com/pelletier/go-toml"
+	"github.com/pkg/errors"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains functions related to editing plugins.toml on disk, edits are made line by line so comments are kept
+
+*/
+
+//pluginHeader - Line that starts a plugin table
+const pluginHeader = "[[plugin]]"
+
+//keyLine - Matches a key = value line, the value may be followed by a comment
+var keyLine = regexp.MustCompile(`^(\s*)([A-Za-z0-9_-]+)(\s*=\s*)(.*)$`)
+
+//pluginFileLines - plugins.toml split into lines
+type pluginFileLines struct {
+	path  string
+	lines []string
+}
+
+//block - Lines of a single plugin table, end is exclusive and is just after the tables last setting
+type block struct {
+	start int
+	end   int
+}
+
+//pluginFilePath - Returns the path of plugins.toml
+func pluginFilePath() string {
+
+	return filepath.Join(utils.GetPlugDir(), pluginFile)
+}
+
+//readPluginFile - Responsible for reading plugins.toml, the bundled plugins.toml is written out first if there is none on disk
+func readPluginFile() (*pluginFileLines, error) {
+
+	path := pluginFilePath()
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		if data, err = Asset(pluginFile); err != nil {
+			return nil, errors.Wrap(err, "error while reading bundled "+pluginFile)
+		}
+		err = ioutil.WriteFile(path, data, 0644)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error while reading "+path)
+	}
+
+	return &pluginFileLines{path: path, lines: strings.Split(string(data), "\n")}, nil
+}
+
+//blocks - Returns every plugin table in the file in order
+func (f *pluginFileLines) blocks() (blocks []block) {
+
+	var starts []int
+	for i, line := range f.lines {
+		if strings.TrimSpace(line) == pluginHeader {
+			starts = append(starts, i)
+		}
+	}
+
+	for i, start := range starts {
+		end := len(f.lines)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		//Blank lines and comments after the last setting belong to whatever follows the table
+		for end-1 > start {
+			line := strings.TrimSpace(f.lines[end-1])
+			if line != "" && !strings.HasPrefix(line, "#") {
+				break
+			}
+			end--
+		}
+		blocks = append(blocks, block{start: start, end: end})
+	}
+
+	return blocks
+}
+
+//find - Returns the table of the plugin with the name passed in
+func (f *pluginFileLines) find(name string) (block, error) {
+
+	for _, b := range f.blocks() {
+		if value, _, ok := f.value(b, "name"); ok {
+			if unquoted, err := strconv.Unquote(value); err == nil && unquoted == name {
+				return b, nil
+			}
+		}
+	}
+
+	return block{}, errors.New("no plugin named: " + name + " in " + pluginFile)
+}
+
+//value - Returns the value of a key in a table with any comment after it removed, along with the line it is on
+func (f *pluginFileLines) value(b block, key string) (string, int, bool) {
+
+	for i := b.start + 1; i < b.end; i++ {
+		match := keyLine.FindStringSubmatch(f.lines[i])
+		if match != nil && match[2] == key {
+			value, _ := splitComment(match[4])
+			return strings.TrimSpace(value), i, true
+		}
+	}
+
+	return "", 0, false
+}
+
+//set - Sets a key in a table to the value passed in, comments after the old value are kept. Keys not in the table are added after the header
+func (f *pluginFileLines) set(b block, key string, value string) {
+
+	if _, i, ok := f.value(b, key); ok {
+		match := keyLine.FindStringSubmatch(f.lines[i])
+		_, comment := splitComment(match[4])
+		if comment != "" {
+			comment = " " + comment
+		}
+		f.lines[i] = match[1] + match[2] + match[3] + value + comment
+		return
+	}
+
+	line := "  " + key + " = " + value
+	f.lines = append(f.lines[:b.start+1], append([]string{line}, f.lines[b.start+1:]...)...)
+}
+
+//remove - Removes a table along with the blank lines after it
+func (f *pluginFileLines) remove(b block) {
+
+	end := b.end
+	for end < len(f.lines) && strings.TrimSpace(f.lines[end]) == "" {
+		end++
+	}
+
+	f.lines = append(f.lines[:b.start], f.lines[end:]...)
+}
+
+//append - Adds a table to the end of the file
+func (f *pluginFileLines) append(table []string) {
+
+	for len(f.lines) > 0 && strings.TrimSpace(f.lines[len(f.lines)-1]) == "" {
+		f.lines = f.lines[:len(f.lines)-1]
+	}
+
+	f.lines = append(f.lines, "")
+	f.lines = append(f.lines, table...)
+	f.lines = append(f.lines, "")
+}
+
+//save - Responsible for checking the edited file still loads and replacing plugins.toml with it
+func (f *pluginFileLines) save() error {
+
+	data := []byte(strings.Join(f.lines, "\n"))
+
+	edited := PluginConfig{}
+	if err := toml.Unmarshal(data, &edited); err != nil {
+		return errors.Wrap(err, "edited "+pluginFile+" is invalid")
+	}
+	for _, plugin := range edited.Plugins {
+		if err := plugin.validate(); err != nil {
+			return err
+		}
+	}
+
+	tmp := f.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return errors.Wrap(err, "error while writing "+tmp)
+	}
+	if err := os.Rename(tmp, f.path); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "error while replacing "+f.path)
+	}
+
+	return nil
+}
+
+//splitComment - Splits a toml value from a comment after it, a # inside a string is part of the value
+func splitComment(rest string) (value string, comment string) {
+
+	inString := false
+	for i := 0; i < len(rest); i++ {
+		switch rest[i] {
+		case '\\':
+			if inString {
+				i++
+			}
+		case '"':
+			inString = !inString
+		case '#':
+			if !inString {
+				return strings.TrimRight(rest[:i], " \t"), rest[i:]
+			}
+		}
+	}
+
+	return rest, ""
+}
+
+//table - Returns the lines of a plugin table with every setting that is not a zero value, enabled is always written
+func table(plugin Plugin) ([]string, error) {
+
+	lines := []string{pluginHeader}
+
+	v := reflect.ValueOf(plugin)
+	for i := 0; i < v.NumField(); i++ {
+
+		key := v.Type().Field(i).Tag.Get("toml")
+		field := v.Field(i)
+
+		empty := field.IsZero() || (field.Kind() == reflect.Slice && field.Len() == 0)
+		if empty && key != "enabled" {
+			continue
+		}
+
+		var value string
+		switch field.Kind() {
+		case reflect.String:
+			value = strconv.Quote(field.String())
+		case reflect.Bool:
+			value = strconv.FormatBool(field.Bool())
+		case reflect.Int64:
+			value = strconv.FormatInt(field.Int(), 10)
+		case reflect.Float64:
+			value = strconv.FormatFloat(field.Float(), 'f', -1, 64)
+			if !strings.Contains(value, ".") {
+				value += ".0"
+			}
+		case reflect.Slice:
+			quoted := make([]string, field.Len())
+			for j := range quoted {
+				quoted[j] = strconv.Quote(field.Index(j).String())
+			}
+			value = "[" + strings.Join(quoted, ", ") + "]"
+		default:
+			return nil, errors.New("plugin setting " + key + " has an unsupported type")
+		}
+
+		lines = append(lines, "  "+key+" = "+value)
+	}
+
+	return lines, nil
+}
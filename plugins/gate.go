@@ -0,0 +1,214 @@
+package plugins
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"malscan/core/lease"
+	"malscan/core/utils"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains the gates that stop a plugin being ran while it is updated, by this malscan or by another one sharing its directories
+
+*/
+
+//pluginGate - Counts the scans running with a plugin and stops new ones starting while it is updated.
+//Scans never block on a gate, a scan that finds its plugin updating runs its other plugins and comes back to it
+type pluginGate struct {
+	mutex    sync.Mutex
+	running  int           //Scans running with the plugin
+	updating bool          //An update holds the gate
+	drained  chan struct{} //Closed once the last running scan leaves while an update waits
+	resumed  chan struct{} //Closed once the update finishes
+}
+
+//gates - One gate per plugin
+var (
+	gates      = make(map[string]*pluginGate)
+	gatesMutex sync.Mutex
+)
+
+//closed - A channel that is always closed, returned when there is nothing to wait for
+var closed = make(chan struct{})
+
+func init() {
+	close(closed)
+}
+
+//gate - Returns the gate of the plugin passed in
+func gate(name string) *pluginGate {
+
+	gatesMutex.Lock()
+	defer gatesMutex.Unlock()
+
+	g, ok := gates[name]
+	if !ok {
+		g = &pluginGate{}
+		gates[name] = g
+	}
+
+	return g
+}
+
+//updateLease - Returns the path of the lease held while a plugin is updated, other malscans do not start scans with the plugin while it is held
+func updateLease(name string) string {
+
+	return filepath.Join(utils.GetRunDir(), "update-"+name+".lease")
+}
+
+//daemonLease - Returns the path of the lease a malscan running as a daemon holds
+func daemonLease(id string) string {
+
+	return filepath.Join(utils.GetRunDir(), "daemon-"+id+".lease")
+}
+
+//enter - Records a scan starting with the plugin, false is returned without waiting when the plugin is being updated
+func (g *pluginGate) enter(name string) bool {
+
+	//Another malscan is updating the plugin
+	external := lease.Held(updateLease(name))
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.updating || external {
+		return false
+	}
+
+	g.running++
+
+	return true
+}
+
+//leave - Records a scan with the plugin finishing
+func (g *pluginGate) leave() {
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.running--
+
+	if g.running == 0 && g.drained != nil {
+		close(g.drained)
+		g.drained = nil
+	}
+}
+
+//resumedCh - Returns a channel that is closed once the plugin is no longer being updated
+func (g *pluginGate) resumedCh(name string) <-chan struct{} {
+
+	g.mutex.Lock()
+	updating, resumed := g.updating, g.resumed
+	g.mutex.Unlock()
+
+	if updating {
+		return resumed
+	}
+
+	if lease.Held(updateLease(name)) {
+		return lease.Released(updateLease(name))
+	}
+
+	return closed
+}
+
+//pause - Responsible for waiting for every running scan of a plugin to finish and holding new scans of it until the function
+//returned is called. Only scans of this plugin are held, every other plugin keeps scanning. The plugins update lease is held
+//for as long as scans are so other malscans hold their new scans too, an update another malscan is running is waited for
+func pause(plugin Plugin) (resume func()) {
+
+	log.Infof("pausing scans with:%s", plugin.Name)
+
+	g := gate(plugin.Name)
+
+	g.mutex.Lock()
+
+	//Only one update of a plugin holds its gate at a time
+	for g.updating {
+		resumed := g.resumed
+		g.mutex.Unlock()
+		<-resumed
+		g.mutex.Lock()
+	}
+
+	g.updating = true
+	g.resumed = make(chan struct{})
+
+	drained := closed
+	if g.running > 0 {
+		g.drained = make(chan struct{})
+		drained = g.drained
+	}
+
+	g.mutex.Unlock()
+
+	held := acquireUpdateLease(plugin.Name)
+
+	<-drained
+
+	log.Debugf("scans with:%s:drained", plugin.Name)
+
+	return func() {
+		if held != nil {
+			held.Release()
+		}
+
+		g.mutex.Lock()
+		g.updating = false
+		close(g.resumed)
+		g.mutex.Unlock()
+
+		log.Infof("resumed scans with:%s", plugin.Name)
+	}
+}
+
+//acquireUpdateLease - Takes the update lease of a plugin, waiting while another malscan holds it.
+//Nil is returned if the lease can not be taken, the update then only holds scans of this malscan
+func acquireUpdateLease(name string) *lease.Lease {
+
+	waiting := false
+
+	for {
+		held, err := lease.Acquire(updateLease(name))
+		if err == nil {
+			return held
+		}
+		if err != lease.ErrHeld {
+			log.WithFields(log.Fields{"err": err}).Errorf("taking update lease of:%s", name)
+			return nil
+		}
+
+		if !waiting {
+			log.Infof("waiting for another malscan to finish updating:%s", name)
+			waiting = true
+		}
+
+		time.Sleep(time.Second)
+	}
+}
+
+//StartDaemonLease - Responsible for holding a lease while malscan runs as a daemon (watch, serve or icap), plugin updates from
+//the command line are refused while one is held as another process can not wait for the daemons scans. The function returned releases it
+func (pconfig PluginConfig) StartDaemonLease() (stop func()) {
+
+	held, err := lease.Acquire(daemonLease(lease.ID))
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("taking daemon lease")
+		return func() {}
+	}
+
+	return held.Release
+}
+
+//DaemonRunning - Tests whether a malscan is running as a daemon
+func DaemonRunning() bool {
+
+	return lease.HeldAny(daemonLease("*"))
+}
@@ -0,0 +1,272 @@
+package plugins
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"malscan/core/docker"
+
+	"github.com/docker/docker/api/types"
+	"github.com/pkg/errors"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains functions related to managing the plugins in plugins.toml
+
+*/
+
+//PluginInfo - A plugin along with whether its runtime has it installed, the digest and update time are only known for docker plugins
+type PluginInfo struct {
+	Plugin
+	Installed bool
+	Digest    string    //Digest of the plugins image
+	Updated   time.Time //When the plugins image was created, updates commit a new image
+}
+
+//Info - Returns every plugin, enabled or not, along with its install status
+func (pconfig PluginConfig) Info() []PluginInfo {
+
+	byRuntime := make(map[string][]Plugin)
+	for _, plugin := range pconfig.Plugins {
+		if plugin.runtimeName() != runtimeDocker {
+			byRuntime[plugin.runtimeName()] = append(byRuntime[plugin.runtimeName()], plugin)
+		}
+	}
+
+	installed := make(map[string]bool)
+	for name, plugins := range byRuntime {
+		for _, plugin := range runtimes[name].Installed(plugins) {
+			installed[plugin.Name] = true
+		}
+	}
+
+	var images []types.ImageSummary
+	for _, plugin := range pconfig.Plugins {
+		if plugin.runtimeName() == runtimeDocker {
+			images = docker.GetIntalledImages()
+			break
+		}
+	}
+
+	infos := make([]PluginInfo, 0, len(pconfig.Plugins))
+	for _, plugin := range pconfig.Plugins {
+
+		info := PluginInfo{Plugin: plugin, Installed: installed[plugin.Name]}
+
+		if plugin.runtimeName() == runtimeDocker {
+			if image, ok := findImage(images, plugin.Image); ok {
+				info.Installed = true
+				info.Digest = imageDigest(image)
+				info.Updated = time.Unix(image.Created, 0)
+			}
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos
+}
+
+//PrintList - Responsible for printing a table of every plugin and its install status
+func (pconfig PluginConfig) PrintList() error {
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tENABLED\tCATEGORY\tRUNTIME\tINSTALLED\tDIGEST\tUPDATED")
+
+	for _, info := range pconfig.Info() {
+
+		digest, updated := "-", "-"
+		if info.Digest != "" {
+			digest = shortDigest(info.Digest)
+		}
+		if !info.Updated.IsZero() {
+			updated = info.Updated.Format(time.RFC3339)
+		}
+
+		fmt.Fprintf(w, "%s\t%t\t%s\t%s\t%t\t%s\t%s\n", info.Name, info.Enabled, info.Category, info.runtimeName(),
+			info.Installed, digest, updated)
+	}
+
+	return w.Flush()
+}
+
+//PrintInfo - Responsible for printing a plugins table from plugins.toml, comments included, followed by its install status
+func (pconfig PluginConfig) PrintInfo(name string) error {
+
+	f, err := readPluginFile()
+	if err != nil {
+		return err
+	}
+
+	b, err := f.find(name)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(strings.TrimRight(strings.Join(f.lines[b.start:b.end], "\n"), "\n "))
+	fmt.Println()
+
+	for _, info := range pconfig.Info() {
+		if info.Name != name {
+			continue
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "installed:\t%t\n", info.Installed)
+		if info.Digest != "" {
+			fmt.Fprintf(w, "digest:\t%s\n", info.Digest)
+		}
+		if !info.Updated.IsZero() {
+			fmt.Fprintf(w, "updated:\t%s\n", info.Updated.Format(time.RFC3339))
+		}
+		return w.Flush()
+	}
+
+	return nil
+}
+
+//AddPlugin - Responsible for adding a plugin to the end of plugins.toml, the name must not already be used
+func (pconfig *PluginConfig) AddPlugin(plugin Plugin) error {
+
+	if plugin.Name == "" {
+		return errors.New("a plugin needs a name")
+	}
+	if plugin.Category != dectection && plugin.Category != enrichment {
+		return errors.New("the category of plugin: " + plugin.Name + " must be " + dectection + " or " + enrichment)
+	}
+	if err := plugin.validate(); err != nil {
+		return err
+	}
+
+	lines, err := table(plugin)
+	if err != nil {
+		return err
+	}
+
+	f, err := readPluginFile()
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.find(plugin.Name); err == nil {
+		return errors.New("a plugin named: " + plugin.Name + " already exists")
+	}
+
+	f.append(lines)
+
+	if err := f.save(); err != nil {
+		return err
+	}
+
+	pconfig.Plugins = append(pconfig.Plugins, plugin)
+
+	return nil
+}
+
+//RemovePlugin - Responsible for removing a plugin from plugins.toml
+func (pconfig *PluginConfig) RemovePlugin(name string) error {
+
+	f, err := readPluginFile()
+	if err != nil {
+		return err
+	}
+
+	b, err := f.find(name)
+	if err != nil {
+		return err
+	}
+
+	f.remove(b)
+
+	if err := f.save(); err != nil {
+		return err
+	}
+
+	for i, plugin := range pconfig.Plugins {
+		if plugin.Name == name {
+			pconfig.Plugins = append(pconfig.Plugins[:i], pconfig.Plugins[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+//setEnabled - Responsible for enabling or disabling a plugin in memory and in plugins.toml
+func (pconfig *PluginConfig) setEnabled(name string, enabled bool) error {
+
+	f, err := readPluginFile()
+	if err != nil {
+		return err
+	}
+
+	b, err := f.find(name)
+	if err != nil {
+		return err
+	}
+
+	f.set(b, "enabled", strconv.FormatBool(enabled))
+
+	if err := f.save(); err != nil {
+		return err
+	}
+
+	for i := range pconfig.Plugins {
+		if pconfig.Plugins[i].Name == name {
+			pconfig.Plugins[i].Enabled = enabled
+		}
+	}
+
+	return nil
+}
+
+//findImage - Returns the installed image tagged as the plugins image, :latest is implied when the plugin gives no tag.
+//A plugin pinned to a digest is found by the repo digests of the image instead
+func findImage(images []types.ImageSummary, image string) (types.ImageSummary, bool) {
+
+	want := docker.NormalizeTag(image)
+
+	for _, summary := range images {
+		refs := summary.RepoTags
+		if strings.Contains(image, "@") {
+			refs = summary.RepoDigests
+		}
+		for _, ref := range refs {
+			if docker.NormalizeTag(ref) == want {
+				return summary, true
+			}
+		}
+	}
+
+	return types.ImageSummary{}, false
+}
+
+//imageDigest - Returns the registry digest of an image, images committed by an update have none so their ID is used instead
+func imageDigest(image types.ImageSummary) string {
+
+	for _, repoDigest := range image.RepoDigests {
+		if i := strings.Index(repoDigest, "@"); i >= 0 {
+			return repoDigest[i+1:]
+		}
+	}
+
+	return image.ID
+}
+
+//shortDigest - Shortens a digest to 12 characters the way docker does
+func shortDigest(digest string) string {
+
+	digest = strings.TrimPrefix(digest, "sha256:")
+	if len(digest) > 12 {
+		digest = digest[:12]
+	}
+
+	return digest
+}
@@ -0,0 +1,47 @@
+package plugins
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Tests finding the installed image of a plugin
+
+*/
+
+func TestFindImage(t *testing.T) {
+
+	images := []types.ImageSummary{
+		{ID: "previous", RepoTags: []string{"malscan/clamav:previous"}},
+		{ID: "clamav-extra", RepoTags: []string{"malscan/clamav-extra:latest", "registry:5000/malscan/clamav:latest"}},
+		{ID: "yara", RepoTags: []string{"malscan/yara:4.0"}, RepoDigests: []string{"malscan/yara@sha256:abc123"}},
+	}
+
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{"malscan/clamav", ""}, //Only the :previous tag an update left behind is installed
+		{"malscan/clamav:previous", "previous"},
+		{"clamav", ""},
+		{"malscan/clamav-extra", "clamav-extra"},
+		{"docker.io/malscan/clamav-extra:latest", "clamav-extra"},
+		{"registry:5000/malscan/clamav", "clamav-extra"},
+		{"malscan/yara", ""},
+		{"malscan/yara:4.0", "yara"},
+		{"malscan/yara@sha256:abc123", "yara"},
+		{"malscan/yara@sha256:def456", ""},
+	}
+
+	for _, test := range tests {
+		image, ok := findImage(images, test.image)
+		if got := image.ID; got != test.want || ok != (test.want != "") {
+			t.Errorf("findImage(%q) = %q, %v, want %q", test.image, got, ok, test.want)
+		}
+	}
+}
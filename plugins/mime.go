@@ -0,0 +1,116 @@
+package plugins
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains functions related to routing files to plugins based on mime type
+
+*/
+
+//mimeFamilies - Aliases that can be used in a plugins mime field in place of listing every mime type for a file family
+var mimeFamilies = map[string][]string{
+	"pe": {
+		"application/vnd.microsoft.portable-executable",
+		"application/x-msdownload",
+		"application/x-dosexec",
+	},
+	"elf": {
+		"application/x-elf",
+		"application/x-executable",
+		"application/x-sharedlib",
+		"application/x-object",
+		"application/x-coredump",
+	},
+	"office": {
+		"application/msword",
+		"application/vnd.ms-excel",
+		"application/vnd.ms-powerpoint",
+		"application/vnd.ms-outlook",
+		"application/vnd.ms-publisher",
+		"application/x-ole-storage",
+		"application/vnd.openxmlformats-officedocument.*",
+		"application/vnd.oasis.opendocument.*",
+		"text/rtf",
+	},
+	"pdf": {
+		"application/pdf",
+	},
+	"archive": {
+		"application/zip",
+		"application/gzip",
+		"application/x-tar",
+		"application/x-bzip2",
+		"application/x-xz",
+		"application/x-7z-compressed",
+		"application/x-rar-compressed",
+		"application/vnd.ms-cab-compressed",
+	},
+	"text": {
+		"text/*",
+	},
+}
+
+//matchMime - Tests a mime type against a plugin mime pattern
+//A pattern is a comma separated list where each entry is either a family alias (pe, elf, office, pdf, archive, text),
+//a mime type or a mime type containing wildcards (application/*), "*" and an empty pattern match everything
+func matchMime(pattern string, mimeType string) bool {
+
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" || pattern == "*" {
+		return true
+	}
+
+	//Drop any parameters such as "; charset=utf-8"
+	mimeType = strings.ToLower(strings.TrimSpace(strings.SplitN(mimeType, ";", 2)[0]))
+	if mimeType == "" {
+		return false
+	}
+
+	for _, entry := range strings.Split(pattern, ",") {
+
+		entry = strings.ToLower(strings.TrimSpace(entry))
+
+		candidates, ok := mimeFamilies[entry]
+		if !ok {
+			candidates = []string{entry}
+		}
+
+		for _, candidate := range candidates {
+			if candidate == "*" {
+				return true
+			}
+			if matched, err := path.Match(candidate, mimeType); err == nil && matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+//filterMime - Splits plugins into the plugins that should run against a file of the mime type passed in
+//and the plugins that should be skipped along with the reason they were skipped
+func filterMime(plugins []Plugin, mimeType string) (matched []Plugin, skipped map[string]string) {
+
+	skipped = make(map[string]string)
+
+	for _, plugin := range plugins {
+		switch {
+		case matchMime(plugin.Mime, mimeType):
+			matched = append(matched, plugin)
+		case mimeType == "":
+			skipped[plugin.Name] = fmt.Sprintf("mime type could not be detected, plugin only accepts %s", plugin.Mime)
+		default:
+			skipped[plugin.Name] = fmt.Sprintf("mime type %s does not match %s", mimeType, plugin.Mime)
+		}
+	}
+
+	return matched, skipped
+}
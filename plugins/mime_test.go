@@ -0,0 +1,110 @@
+package plugins
+
+import (
+	"reflect"
+	"testing"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Tests routing files to plugins by mime type
+
+*/
+
+func TestMatchMime(t *testing.T) {
+
+	tests := []struct {
+		pattern  string
+		mimeType string
+		want     bool
+	}{
+		//Empty patterns and * match everything, a file whose type could not be detected only matches them
+		{"", "application/pdf", true},
+		{"*", "application/pdf", true},
+		{" * ", "", true},
+		{"pdf", "", false},
+
+		//Families
+		{"pe", "application/x-dosexec", true},
+		{"pe", "application/vnd.microsoft.portable-executable", true},
+		{"elf", "application/x-sharedlib", true},
+		{"elf", "application/x-dosexec", false},
+		{"office", "application/vnd.openxmlformats-officedocument.wordprocessingml.document", true},
+		{"office", "application/vnd.oasis.opendocument.text", true},
+		{"office", "application/pdf", false},
+		{"archive", "application/zip", true},
+		{"text", "text/x-shellscript", true},
+		{"text", "application/json", false},
+		{"PE", "application/x-dosexec", true},
+
+		//Mime types and wildcards
+		{"application/pdf", "application/pdf", true},
+		{"application/pdf", "application/x-pdf", false},
+		{"application/*", "application/x-dosexec", true},
+		{"application/*", "text/plain", false},
+		{"*/x-*", "application/x-dosexec", true},
+		{"application/x-ms*", "application/x-msdownload", true},
+		{"application/[", "application/[", false},
+
+		//Parameters and case are ignored
+		{"text/plain", "text/plain; charset=utf-8", true},
+		{"text/plain", "Text/Plain", true},
+
+		//Comma lists match if any entry matches
+		{"pe,elf", "application/x-executable", true},
+		{"pe, elf", "application/x-dosexec", true},
+		{"pdf,application/zip", "application/zip", true},
+		{"pdf, text/*", "text/html", true},
+		{"pe,elf", "application/pdf", false},
+		{"pe,,", "application/pdf", false},
+	}
+
+	for _, test := range tests {
+		if got := matchMime(test.pattern, test.mimeType); got != test.want {
+			t.Errorf("matchMime(%q, %q) = %v, want %v", test.pattern, test.mimeType, got, test.want)
+		}
+	}
+}
+
+func TestFilterMime(t *testing.T) {
+
+	plugins := []Plugin{
+		{Name: "clamav"},
+		{Name: "manalyze", Mime: "pe"},
+		{Name: "pdfid", Mime: "pdf"},
+	}
+
+	tests := []struct {
+		mimeType string
+		matched  []string
+		skipped  []string
+	}{
+		{"application/x-dosexec", []string{"clamav", "manalyze"}, []string{"pdfid"}},
+		{"application/pdf", []string{"clamav", "pdfid"}, []string{"manalyze"}},
+		{"", []string{"clamav"}, []string{"manalyze", "pdfid"}},
+	}
+
+	for _, test := range tests {
+
+		matched, skipped := filterMime(plugins, test.mimeType)
+
+		var names []string
+		for _, plugin := range matched {
+			names = append(names, plugin.Name)
+		}
+		if !reflect.DeepEqual(names, test.matched) {
+			t.Errorf("filterMime(%q) matched %v, want %v", test.mimeType, names, test.matched)
+		}
+
+		if len(skipped) != len(test.skipped) {
+			t.Errorf("filterMime(%q) skipped %v, want %v", test.mimeType, skipped, test.skipped)
+		}
+		for _, name := range test.skipped {
+			if skipped[name] == "" {
+				t.Errorf("filterMime(%q) did not give a reason for skipping %s", test.mimeType, name)
+			}
+		}
+	}
+}
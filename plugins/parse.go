@@ -3,6 +3,8 @@ package plugins
 import (
 	"encoding/json"
 
+	"malscan/structs"
+
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
@@ -15,35 +17,35 @@ Purpose: Contains functions and structs related to loading malscans plugins
 
 */
 
-func parseResult(buf []byte, plugName string) (parsed json.RawMessage) {
+func parseResult(buf []byte, plugName string) (parsed json.RawMessage, err error) {
 
 	log.Debugf("parsing raw results for:%s", plugName)
 
-	if err := json.Unmarshal(buf, &parsed); err != nil {
-		log.Error(errors.Wrap(err, "error unmarshaling while parsing results"))
+	if err = json.Unmarshal(buf, &parsed); err != nil {
+		return nil, errors.Wrap(err, "error unmarshaling while parsing results")
 	}
 
-	return parsed
+	return parsed, nil
 
 }
 
-func testInfected(buf []byte, plugName *string) bool {
+func testInfected(buf []byte, plugName *string) (bool, error) {
 
 	log.Debugf("testing infected for:%s", *plugName)
 
 	var avresult map[string]map[string]interface{}
 
 	if err := json.Unmarshal(buf, &avresult); err != nil {
-		log.Error(errors.Wrap(err, "error unmarshaling while testing infected"))
+		return false, errors.Wrap(err, "error unmarshaling while testing infected")
 	}
 
 	infected, ok := avresult["analysis"]["infected"].(bool)
 
 	if !ok {
-		log.Error("error while trying to test infected for: ", *plugName)
+		return false, errors.New("plugin output is missing analysis.infected")
 	}
 
-	return infected
+	return infected, nil
 
 }
 
@@ -60,9 +62,34 @@ func parseAnalysisResult(buf []byte, plugName *string) (result string) {
 	result, ok := avresult["analysis"]["result"].(string)
 
 	if !ok {
-		log.Error("error while trying to test infected for: ", *plugName)
+		log.Debug("no analysis result for: ", *plugName)
 	}
 
 	return result
 
 }
+
+//parseVersion - Parses the engine_version, signature_version and signature_date a plugin prints, either at the top level
+//as the version command does or inside analysis alongside a result
+func parseVersion(buf []byte) (structs.PluginVersion, error) {
+
+	var output struct {
+		structs.PluginVersion
+		Analysis structs.PluginVersion `json:"analysis"`
+	}
+
+	if err := json.Unmarshal(buf, &output); err != nil {
+		return structs.PluginVersion{}, errors.Wrap(err, "error unmarshaling while parsing version")
+	}
+
+	version := output.PluginVersion
+	if version == (structs.PluginVersion{}) {
+		version = output.Analysis
+	}
+
+	if version == (structs.PluginVersion{}) {
+		return version, errors.New("plugin output has no engine_version, signature_version or signature_date")
+	}
+
+	return version, nil
+}
@@ -6,9 +6,10 @@ import (
 	"malscan/core/docker"
 	"malscan/core/utils"
 	"path/filepath"
-	"strings"
 
+	units "github.com/docker/go-units"
 	toml "github.com/pelletier/go-toml"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -20,14 +21,66 @@ const (
 )
 
 type Plugin struct {
-	Enabled     bool   `toml:"enabled"`
-	Name        string `toml:"name"`
-	Description string `toml:"description"`
-	Category    string `toml:"category"`
-	Image       string `toml:"image"`
-	Repository  string `toml:"repository"`
-	Updatable   bool   `toml:"updatable"`
-	Mime        string `toml:"mime"`
+	Enabled       bool     `toml:"enabled"`
+	Name          string   `toml:"name"`
+	Description   string   `toml:"description"`
+	Category      string   `toml:"category"`
+	Image         string   `toml:"image"`
+	Repository    string   `toml:"repository"`
+	Updatable     bool     `toml:"updatable"`
+	Mime          string   `toml:"mime"`
+	Runtime       string   `toml:"runtime"`
+	Command       string   `toml:"command"`
+	Args          []string `toml:"args"`
+	Env           []string `toml:"env"`
+	Rules         []string `toml:"rules"`
+	Address       string   `toml:"address"`
+	Timeout       string   `toml:"timeout"`
+	UpdateTimeout string   `toml:"update_timeout"`
+	Memory        string   `toml:"memory"`
+	CPUs          float64  `toml:"cpus"`
+	PidsLimit     int64    `toml:"pids_limit"`
+
+	//Staleness thresholds, override the ones in the staleness section of the malscan config
+	MaxUpdateAge    string `toml:"max_update_age"`
+	MaxSignatureAge string `toml:"max_signature_age"`
+
+	//Opt outs from the hardened container profile, only set these for engines that truly need them
+	AllowNetwork       bool `toml:"allow_network"`
+	WritableSample     bool `toml:"writable_sample"`
+	WritableRootfs     bool `toml:"writable_rootfs"`
+	KeepCapabilities   bool `toml:"keep_capabilities"`
+	AllowNewPrivileges bool `toml:"allow_new_privileges"`
+	DisableSeccomp     bool `toml:"disable_seccomp"`
+}
+
+//runOptions - Converts the plugins timeout, resource limits and sandbox opt outs into the options used when running its container
+func (plugin Plugin) runOptions() (options docker.RunOptions, err error) {
+
+	if options.Timeout, err = plugin.timeout(); err != nil {
+		return options, err
+	}
+
+	if plugin.Memory != "" {
+		options.Memory, err = units.RAMInBytes(plugin.Memory)
+		if err != nil {
+			return options, errors.Wrap(err, "invalid memory limit for plugin: "+plugin.Name)
+		}
+	}
+
+	options.NanoCPUs = int64(plugin.CPUs * 1e9)
+	options.PidsLimit = plugin.PidsLimit
+
+	options.AllowNetwork = plugin.AllowNetwork
+	options.WritableSample = plugin.WritableSample
+	options.WritableRootfs = plugin.WritableRootfs
+	options.KeepCapabilities = plugin.KeepCapabilities
+	options.AllowNewPrivileges = plugin.AllowNewPrivileges
+	options.DisableSeccomp = plugin.DisableSeccomp
+
+	options.Plugin = plugin.Name
+
+	return options, nil
 }
 
 type PluginConfig struct {
@@ -61,30 +114,28 @@ func (pconfig PluginConfig) Load() PluginConfig {
 		log.WithFields(log.Fields{"err": err}).Fatalf("Failed to unmarshal %s", pluginFile)
 	}
 
+	for _, plugin := range pconfig.Plugins {
+		if err := plugin.validate(); err != nil {
+			log.WithFields(log.Fields{"err": err}).Fatalf("Failed to load %s", pluginFile)
+		}
+	}
+
+	pconfig.startYaraEngines()
+
 	return pconfig
 
 }
 
-//EnablePlugin - Responsible for enabling a plugin
-func (pconfig PluginConfig) EnablePlugin(name string) {
-
-	for _, plugin := range pconfig.Plugins {
+//EnablePlugin - Responsible for enabling a plugin, the change is written to plugins.toml
+func (pconfig *PluginConfig) EnablePlugin(name string) error {
 
-		if plugin.Name == name {
-			plugin.Enabled = true
-		}
-	}
+	return pconfig.setEnabled(name, true)
 }
 
-//DisablePlugin - Responsible for disabling a plugin
-func (pconfig PluginConfig) DisablePlugin(name string) {
-
-	for _, plugin := range pconfig.Plugins {
+//DisablePlugin - Responsible for disabling a plugin, the change is written to plugins.toml
+func (pconfig *PluginConfig) DisablePlugin(name string) error {
 
-		if plugin.Name == name {
-			plugin.Enabled = false
-		}
-	}
+	return pconfig.setEnabled(name, false)
 }
 
 func (pconfig PluginConfig) GetEnabledPlugins() (enabled []Plugin) {
@@ -97,39 +148,59 @@ func (pconfig PluginConfig) GetEnabledPlugins() (enabled []Plugin) {
 	return enabled
 }
 
+//GetEnabledInstalledPlugins - Returns the enabled plugins that are ready to be ran by their runtime
+//a runtime is only asked about its plugins when at least one of them is enabled, so docker is never contacted
+//unless a docker plugin is enabled
 func (pconfig PluginConfig) GetEnabledInstalledPlugins() (enabledInstalled []Plugin) {
 
-	installed := docker.GetIntalledImages()
+	enabled := pconfig.GetEnabledPlugins()
 
-	for _, plugin := range pconfig.GetEnabledPlugins() {
-		for _, installed := range installed {
-			if strings.Contains(installed.RepoTags[0], plugin.Image) {
-				enabledInstalled = append(enabledInstalled, plugin)
-			}
+	byRuntime := make(map[string][]Plugin)
+	for _, plugin := range enabled {
+		byRuntime[plugin.runtimeName()] = append(byRuntime[plugin.runtimeName()], plugin)
+	}
+
+	installed := make(map[string]bool)
+	for name, plugins := range byRuntime {
+		for _, plugin := range runtimes[name].Installed(plugins) {
+			installed[plugin.Name] = true
 		}
+	}
 
+	for _, plugin := range enabled {
+		if installed[plugin.Name] {
+			enabledInstalled = append(enabledInstalled, plugin)
+		}
 	}
 	return enabledInstalled
 }
 
-func (pconfig PluginConfig) GetEnabledDectionPlugins() (enabled []Plugin) {
+//GetEnabledDectionPlugins - Returns the enabled and installed av plugins whose mime pattern matches the mime type passed in
+//plugins that do not match are returned in skipped along with the reason they were skipped
+func (pconfig PluginConfig) GetEnabledDectionPlugins(mimeType string) (enabled []Plugin, skipped map[string]string) {
+
+	var detection []Plugin
 
 	for _, plugin := range pconfig.GetEnabledInstalledPlugins() {
 		if plugin.Category == dectection {
-			enabled = append(enabled, plugin)
+			detection = append(detection, plugin)
 		}
 	}
-	return enabled
+	return filterMime(detection, mimeType)
 }
 
-func (pconfig PluginConfig) GetEnabledEnrichmentPlugins() (enabled []Plugin) {
+//GetEnabledEnrichmentPlugins - Returns the enabled and installed er plugins whose mime pattern matches the mime type passed in
+//plugins that do not match are returned in skipped along with the reason they were skipped
+func (pconfig PluginConfig) GetEnabledEnrichmentPlugins(mimeType string) (enabled []Plugin, skipped map[string]string) {
+
+	var enrichers []Plugin
 
 	for _, plugin := range pconfig.GetEnabledInstalledPlugins() {
 		if plugin.Category == enrichment {
-			enabled = append(enabled, plugin)
+			enrichers = append(enrichers, plugin)
 		}
 	}
-	return enabled
+	return filterMime(enrichers, mimeType)
 }
 
 /*
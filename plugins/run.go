@@ -1,16 +1,20 @@
 package plugins
 
 import (
+	"context"
 	"encoding/json"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
 	malscanconfig "malscan/config"
 	"malscan/core/alert"
+	"malscan/core/cache"
 	"malscan/core/docker"
 	"malscan/core/utils"
 	hash "malscan/core/utils/hash"
+	mime "malscan/core/utils/mime"
 	"malscan/elastic"
 	"malscan/structs"
 
@@ -28,7 +32,7 @@ Purpose: Contains functions related running malscan plugins
 //RunPlugin - Responsible for running a single plugin passed into the function against a file
 func RunPlugin(filename *string, image string) {
 	log.Debug("RunPlugin - running plugin")
-	docker.RunContainerOnFile(image, filename)
+	docker.RunContainerOnFile(context.Background(), image, filename, docker.RunOptions{})
 }
 
 //RunPluginUpdate - Responsible for running a update on a single plugin
@@ -43,8 +47,8 @@ func (pconfig PluginConfig) RunPluginUpdate(plugName string) (msg string) {
 
 		if plugin.Name == plugName {
 			found = true
-			if plugin.Updatable == true {
-				status := docker.RunContainerUpdate(plugin.Image)
+			if plugin.Updatable == true && plugin.canUpdate() {
+				status := plugin.update()
 				msg = plugin.Name + " Update: " + status
 				break
 			} else {
@@ -56,6 +60,8 @@ func (pconfig PluginConfig) RunPluginUpdate(plugName string) (msg string) {
 
 	}
 
+	resetSignatures() //An updated plugin invalidates the reports cached with its old signatures
+
 	if found != true {
 		log.Debug("Could not update: " + plugName + ", either the plugin does not exist or it is not enabled")
 		msg = "Could not update: " + plugName + ", either the plugin does not exist or it is not enabled"
@@ -79,9 +85,9 @@ func (pconfig PluginConfig) RunPluginUpdateAll() (statusAndTime map[string]map[s
 
 	for _, plugin := range enabled {
 
-		if plugin.Updatable == true {
+		if plugin.Updatable == true && plugin.canUpdate() {
 
-			msg = docker.RunContainerUpdate(plugin.Image)
+			msg = plugin.update()
 
 			status[plugin.Name] = msg
 
@@ -92,257 +98,314 @@ func (pconfig PluginConfig) RunPluginUpdateAll() (statusAndTime map[string]map[s
 
 	//docker.Prune() (NOT SAFE TO USE) - containers now removed invidually in container.go
 
+	resetSignatures() //An updated plugin invalidates the reports cached with its old signatures
+
 	statusAndTime[time.Now().Format(time.RFC3339)] = status
 
 	return statusAndTime
 }
 
 //RunEnabledConcurrent - Responsible for running all plugins against a file, except each plugin is run concurrently
-func (pconfig PluginConfig) RunEnabledConcurrent(filename string) {
+//filename can either be a file in the filestore or an absolute path, the report for the file is returned once all plugins have run
+func (pconfig PluginConfig) RunEnabledConcurrent(filename string) structs.FullFileReport {
 
 	log.Debug("running enabled plugins concurrently")
 
-	fileReport := structs.FullFileReport{}
+	return pconfig.RunEnabledLimit(context.Background(), filename, 0)
+}
 
-	//Set default/static values
+//RunEnabled - Responsible for running all plugins against a file one plugin at a time
+func (pconfig PluginConfig) RunEnabled(filename string) structs.FullFileReport {
+
+	log.Debug("running enabled plugins")
+
+	return pconfig.RunEnabledLimit(context.Background(), filename, 1)
+}
+
+//RunEnabledLimit - Responsible for running all plugins against a file with at most limit plugins running at once,
+//a limit of 0 runs every plugin at once. er plugins are ran once all av plugins have finished if there was a detection.
+//Archives are unpacked and every file inside them is scanned as a child of the archive.
+//Cancelling ctx stops any running plugins, the report of a cancelled scan is incomplete and is not indexed
+func (pconfig PluginConfig) RunEnabledLimit(ctx context.Context, filename string, limit int) structs.FullFileReport {
+
+	ctx = withScanID(ctx) //Every container ran for this file and the files unpacked from it is labelled with the same scan id
+
+	basename := filepath.Base(filename)
+
+	name := basename
 	if malscanconfig.Values.Alert.DynamicRemoteHost == true {
-		fileReport.File.Name = strings.Replace(filename, utils.ParseInstance(filename), "", -1)
-	} else {
-		fileReport.File.Name = filename
+		if instance := utils.ParseInstance(basename); instance != "" {
+			name = strings.Replace(basename, instance, "", -1)
+		}
 	}
 
+	fileReport := pconfig.scanFile(ctx, filename, name, filename, limit)
+	fileReport.File.Tags = tags(basename)
+
+	pconfig.scanChildren(ctx, filename, &fileReport, limit)
+
+	if ctx.Err() != nil {
+		log.Debugf("scan cancelled:%s", filename)
+		return fileReport
+	}
+
+	if malscanconfig.Values.Elasticsearch.Enabled == true {
+		elastic.Index(fileReport, &filename) //Post es results
+	}
+
+	return fileReport
+}
+
+//scanFile - Responsible for running all plugins against a single file, alerts are generated as alertAs
+//so files unpacked from an archive alert under the name of the archive they came from
+func (pconfig PluginConfig) scanFile(ctx context.Context, filename string, name string, alertAs string, limit int) structs.FullFileReport {
+
+	fileReport := structs.FullFileReport{}
+
+	//Set default/static values
+	fileReport.File.Name = name
+
 	fileReport.File.Sha1, _ = hash.GenerateFileSha1(&filename)
 	fileReport.File.Md5, _ = hash.GenerateFileMd5(&filename)
+	fileReport.File.Sha256, _ = hash.GenerateFileSha256(&filename)
 	fileReport.File.Malware.Infected = false
 
 	//Initialize maps
 	fileReport.File.Malware.Analyzers.RawAnalysis.AntiVirus = make(map[string]json.RawMessage)
 	fileReport.File.Malware.Analyzers.RawAnalysis.Enricher = make(map[string]json.RawMessage)
+	fileReport.File.Malware.Analyzers.Status = make(map[string]structs.PluginStatus)
 
-	var pluginsUsed []string     //Stores plugins used in the analysis
-	var pluginsDetected []string //Stores plugins that detected malware
-
-	detected := false //Used to know if there has been a detection
-
-	var mutex = &sync.Mutex{} //Used so only one av can generate an alert
-
-	enabledAV := pconfig.GetEnabledDectionPlugins() //Stores enabled av plugins
-
-	var wgAV sync.WaitGroup //Used to wait for all av plugins to finish before posting results to es
-	var wgER sync.WaitGroup //Used to wait for all er plugins to finish before posting results to es
+	//Reuse the report of a file that was already scanned with the same plugin signatures and verdict policy
+	policy := loadVerdictPolicy()
+	signatures := pconfig.signatures(policy)
+	if cached, ok := cache.Get(fileReport.File.Sha256, signatures); ok {
+		return fromCache(cached, name, alertAs, policy)
+	}
 
-	wgAV.Add(len(enabledAV)) //Tell waitgroup how many av plugins to wait for (er is done in the run er plugins function)
+	//Detect the file type so only plugins that handle this type of file are ran
+	fileReport.File.Mime = mime.FileType(filename)
+	log.Infof("file type:%s", fileReport.File.Mime)
 
-	for _, plugin := range enabledAV { //Range over all enabled av plugins
+	var pluginsUsed []string     //Stores plugins used in the analysis
+	var pluginsDetected []string //Stores plugins that detected malware
 
-		pluginsUsed = append(pluginsUsed, plugin.Name) //Append used plugin
+	detectionOutput := make(map[string][]byte) //Stores the output of plugins that detected malware, used for alerting
 
-		go func(plugin Plugin, detected *bool) { //Detach running of plugin as goroutine
+	var mutex = &sync.Mutex{} //Used so only one plugin at a time writes to the report
 
-			dockerOutput := docker.RunContainerOnFile(plugin.Image, &filename) //Run plugin container
+	enabledAV, skippedAV := pconfig.GetEnabledDectionPlugins(fileReport.File.Mime) //Stores enabled av plugins that accept this file type
+	for name, status := range skipped(skippedAV) {
+		fileReport.File.Malware.Analyzers.Status[name] = status
+	}
 
-			//If there has been no detection test if the current av has detected malware
-			mutex.Lock()
-			if *detected == false {
-				if testInfected(dockerOutput, &plugin.Name) == true {
-					go alert.Generate(dockerOutput, &filename) //If there is an av detection generate an alert detached as goroutine
-					*detected = true
-					fileReport.File.Malware.Infected = true
+	runLimited(ctx, enabledAV, limit, func(plugin Plugin) {
 
-					go func() { pconfig.RunEnricherPlugins(&filename, &fileReport, &pluginsUsed, &wgER) }() //Run er plugins detached as goroutine
+		dockerOutput, status := runPlugin(ctx, plugin, &filename)
 
-				}
+		var infected bool
+		var parsedResult json.RawMessage
+		var analysisResult string
 
+		if status.State == structs.StateOK {
+			var err error
+			if parsedResult, err = parseResult(dockerOutput, plugin.Name); err != nil {
+				status = failed(status, plugin.Name, err)
+			} else if infected, err = testInfected(dockerOutput, &plugin.Name); err != nil {
+				status = failed(status, plugin.Name, err)
+			} else {
+				analysisResult = parseAnalysisResult(dockerOutput, &plugin.Name)
 			}
-			mutex.Unlock()
+		}
 
-			//If there plugin deteced malware add to the list of plugins that detected malware
-			if testInfected(dockerOutput, &plugin.Name) == true {
-				pluginsDetected = append(pluginsDetected, plugin.Name)
-			}
+		mutex.Lock()
+		defer mutex.Unlock()
 
-			//Parse raw results
-			parsedResult := parseResult(dockerOutput, plugin.Name)
-			fileReport.File.Malware.Analyzers.RawAnalysis.AntiVirus[plugin.Name] = parsedResult
+		pluginsUsed = append(pluginsUsed, plugin.Name) //Append used plugin
+		fileReport.File.Malware.Analyzers.Status[plugin.Name] = status
 
-			//Parse and add results to list of av results
-			analysisResult := parseAnalysisResult(dockerOutput, &plugin.Name)
-			if analysisResult != "" {
-				fileReport.File.Malware.Results = append(fileReport.File.Malware.Results, analysisResult)
-			}
+		if status.State != structs.StateOK {
+			return
+		}
 
-			wgAV.Done() //Inform wait group this plugin has finished
+		if infected == true {
+			pluginsDetected = append(pluginsDetected, plugin.Name) //Add to the list of plugins that detected malware
+			detectionOutput[plugin.Name] = dockerOutput
+		}
 
-		}(plugin, &detected)
-	}
+		//Add raw results
+		fileReport.File.Malware.Analyzers.RawAnalysis.AntiVirus[plugin.Name] = parsedResult
 
-	//Wait for plugins to finish
-	wgAV.Wait()
-	time.Sleep(time.Second * 5)
-	wgER.Wait()
+		//Add results to list of av results
+		if analysisResult != "" {
+			fileReport.File.Malware.Results = append(fileReport.File.Malware.Results, analysisResult)
+		}
+	})
 
 	//Set plugins that detected malware
 	fileReport.File.Malware.Analyzers.Names = pluginsDetected
 
-	//Set tags
-	fileReport.File.Tags = append(fileReport.File.Tags, malscanconfig.Values.Env.Client)
-	fileReport.File.Tags = append(fileReport.File.Tags, malscanconfig.Values.Env.Site)
-	fileReport.File.Tags = append(fileReport.File.Tags, malscanconfig.Values.Env.Network)
-	if malscanconfig.Values.Alert.DynamicRemoteHost == true {
-		fileReport.File.Tags = append(fileReport.File.Tags, "sen"+string(utils.ParseInstance(filename)[7]))
+	//Set the overall verdict from the verdict policy, a file no av could scan is unknown rather than clean
+	fileReport.File.Malware.Verdict, fileReport.File.Malware.Score = policy.evaluate(fileReport.File.Malware.Analyzers.Status, pluginsDetected, enabledAV)
+	fileReport.File.Malware.Policy = policy.String()
+	fileReport.File.Malware.Infected = fileReport.File.Malware.Verdict == structs.VerdictMalicious
+
+	//A cancelled scan is ran again in full so it is not alerted on, enriched or cached
+	if ctx.Err() != nil {
+		return fileReport
 	}
 
-	fileReport.File.Tags = append(fileReport.File.Tags, "malscan")
+	raiseAlert(fileReport, detectionOutput, policy, alertAs)
+
+	//Only enrich files that have been detected as malware
+	if fileReport.File.Malware.Verdict == structs.VerdictMalicious || fileReport.File.Malware.Verdict == structs.VerdictSuspicious {
+		pconfig.RunEnricherPlugins(ctx, &filename, &fileReport, &pluginsUsed, limit)
+	}
 
 	//Set timestamp of scan
 	fileReport.File.Date = time.Now().Format(time.RFC3339)
 
-	log.Infof("analyzed:%s:with:%s:infected:%t", filename, strings.Join(pluginsUsed, ","), detected)
+	log.Infof("analyzed:%s:with:%s:verdict:%s", filename, strings.Join(pluginsUsed, ","), fileReport.File.Malware.Verdict)
 
 	//docker.Prune() //Clean docker system (NOT SAFE TO USE) - containers now removed invidually in container.go
 	// no but seriously using this could make a lot of people mad
 
-	if malscanconfig.Values.Elasticsearch.Enabled == true {
-		elastic.Index(fileReport, &filename) //Post es results
+	if cacheable(fileReport) && ctx.Err() == nil {
+		cache.Put(fileReport, signatures)
 	}
 
-}
-
-//RunEnabledConcurrentWithChannel - Responsible for running all plugins against a file, except each plugin is run concurrently
-//Includes a channel for recieving a signal when the function has completed
-func (pconfig PluginConfig) RunEnabledConcurrentWithChannel(filename string, done chan bool) {
-
-	log.Debug("Running enabled plugins concurrently")
-
-	pconfig.RunEnabledConcurrent(filename)
-
-	done <- true
+	return fileReport
 
 }
 
-func (pconfig PluginConfig) RunEnricherPlugins(filename *string, fileReport *structs.FullFileReport, pluginsUsed *[]string, wgER *sync.WaitGroup) {
-
-	enabledER := pconfig.GetEnabledEnrichmentPlugins()
-
-	wgER.Add(len(enabledER))
-
-	for _, plugin := range enabledER {
-
-		*pluginsUsed = append(*pluginsUsed, plugin.Name)
-
-		go func(plugin Plugin) {
+//raiseAlert - Only alerts when the policy says so, the alert is generated from the first detection (preferring one that is sufficient alone)
+func raiseAlert(fileReport structs.FullFileReport, detectionOutput map[string][]byte, policy verdictPolicy, alertAs string) {
 
-			dockerOutput := docker.RunContainerOnFile(plugin.Image, filename)
+	pluginsDetected := fileReport.File.Malware.Analyzers.Names
 
-			parsedResult := parseResult(dockerOutput, plugin.Name)
-			fileReport.File.Malware.Analyzers.RawAnalysis.Enricher[plugin.Name] = parsedResult
-
-			wgER.Done()
+	if !shouldAlert(fileReport.File.Malware.Verdict) || len(pluginsDetected) == 0 {
+		return
+	}
 
-		}(plugin)
+	alertFrom := pluginsDetected[0]
+	for _, name := range pluginsDetected {
+		if policy.sufficient[name] {
+			alertFrom = name
+			break
+		}
 	}
-}
 
-//RunEnabled - Responsible for running all plugins against a file, except each plugin is run concurrently
-func (pconfig PluginConfig) RunEnabled(filename string) {
+	go alert.Generate(detectionOutput[alertFrom], &alertAs) //Generate an alert detached as goroutine
+}
 
-	log.Debug("running enabled plugins")
+//tags - Returns the tags set on every report for a file
+func tags(basename string) (fileTags []string) {
 
-	fileReport := structs.FullFileReport{}
-
-	//Set default/static values
+	fileTags = append(fileTags, malscanconfig.Values.Env.Client)
+	fileTags = append(fileTags, malscanconfig.Values.Env.Site)
+	fileTags = append(fileTags, malscanconfig.Values.Env.Network)
 	if malscanconfig.Values.Alert.DynamicRemoteHost == true {
-		fileReport.File.Name = strings.Replace(filename, utils.ParseInstance(filename), "", -1)
-	} else {
-		fileReport.File.Name = filename
+		if instance := utils.ParseInstance(basename); len(instance) > 7 {
+			fileTags = append(fileTags, "sen"+string(instance[7]))
+		}
 	}
 
-	fileReport.File.Sha1, _ = hash.GenerateFileSha1(&filename)
-	fileReport.File.Md5, _ = hash.GenerateFileMd5(&filename)
-	fileReport.File.Malware.Infected = false
-
-	//Initialize maps
-	fileReport.File.Malware.Analyzers.RawAnalysis.AntiVirus = make(map[string]json.RawMessage)
-	fileReport.File.Malware.Analyzers.RawAnalysis.Enricher = make(map[string]json.RawMessage)
-
-	var pluginsUsed []string     //Stores plugins used in the analysis
-	var pluginsDetected []string //Stores plugins that detected malware
-
-	detected := false //Used to know if there has been a detection
-
-	enabledAV := pconfig.GetEnabledDectionPlugins() //Stores enabled av plugins
+	fileTags = append(fileTags, "malscan")
 
-	var wgER sync.WaitGroup //Used to wait for all er plugins to finish before posting results to es
+	return fileTags
+}
 
-	for _, plugin := range enabledAV { //Range over all enabled av plugins
+//RunEnricherPlugins - Responsible for running all er plugins against a file with at most limit plugins running at once,
+//blocks until every er plugin has finished
+func (pconfig PluginConfig) RunEnricherPlugins(ctx context.Context, filename *string, fileReport *structs.FullFileReport, pluginsUsed *[]string, limit int) {
 
-		pluginsUsed = append(pluginsUsed, plugin.Name) //Append used plugin
+	enabledER, skippedER := pconfig.GetEnabledEnrichmentPlugins(fileReport.File.Mime)
+	for name, status := range skipped(skippedER) {
+		fileReport.File.Malware.Analyzers.Status[name] = status
+	}
 
-		dockerOutput := docker.RunContainerOnFile(plugin.Image, &filename) //Run plugin container
+	var mutex = &sync.Mutex{} //Used so only one plugin at a time writes to the report
 
-		//If there has been no detection test if the current av has detected malware
+	runLimited(ctx, enabledER, limit, func(plugin Plugin) {
 
-		if detected == false {
-			if testInfected(dockerOutput, &plugin.Name) == true {
-				go alert.Generate(dockerOutput, &filename) //If there is an av detection generate an alert detached as goroutine
-				detected = true
-				fileReport.File.Malware.Infected = true
+		dockerOutput, status := runPlugin(ctx, plugin, filename)
 
-				go func() { pconfig.RunEnricherPlugins(&filename, &fileReport, &pluginsUsed, &wgER) }() //Run er plugins detached as goroutine
+		var parsedResult json.RawMessage
 
+		if status.State == structs.StateOK {
+			var err error
+			if parsedResult, err = parseResult(dockerOutput, plugin.Name); err != nil {
+				status = failed(status, plugin.Name, err)
 			}
-
 		}
 
-		//If a plugin deteced malware add to the list of plugins that detected malware
-		if testInfected(dockerOutput, &plugin.Name) == true {
-			pluginsDetected = append(pluginsDetected, plugin.Name)
-		}
+		mutex.Lock()
+		defer mutex.Unlock()
 
-		//Parse raw results
-		parsedResult := parseResult(dockerOutput, plugin.Name)
-		fileReport.File.Malware.Analyzers.RawAnalysis.AntiVirus[plugin.Name] = parsedResult
+		*pluginsUsed = append(*pluginsUsed, plugin.Name)
+		fileReport.File.Malware.Analyzers.Status[plugin.Name] = status
 
-		//Parse and add results to list of av results
-		analysisResult := parseAnalysisResult(dockerOutput, &plugin.Name)
-		if analysisResult != "" {
-			fileReport.File.Malware.Results = append(fileReport.File.Malware.Results, analysisResult)
+		if status.State == structs.StateOK {
+			fileReport.File.Malware.Analyzers.RawAnalysis.Enricher[plugin.Name] = parsedResult
 		}
+	})
+}
+
+//runLimited - Responsible for calling run for every plugin passed in with at most limit calls running at once,
+//a limit of 0 runs every plugin at once. Blocks until every call has returned.
+//Plugins that are being updated are left until every other plugin has been started, the file then waits for their updates
+//without holding a slot. Once ctx is cancelled they are ran straight away so they report the scan as cancelled
+func runLimited(ctx context.Context, plugins []Plugin, limit int, run func(plugin Plugin)) {
 
+	if limit <= 0 || limit > len(plugins) {
+		limit = len(plugins)
 	}
 
-	time.Sleep(time.Second * 5)
-	wgER.Wait()
+	slots := make(chan struct{}, limit)
 
-	//Set plugins that detected malware
-	fileReport.File.Malware.Analyzers.Names = pluginsDetected
+	var wg sync.WaitGroup
 
-	//Set tags
-	fileReport.File.Tags = append(fileReport.File.Tags, malscanconfig.Values.Env.Client)
-	fileReport.File.Tags = append(fileReport.File.Tags, malscanconfig.Values.Env.Site)
-	fileReport.File.Tags = append(fileReport.File.Tags, malscanconfig.Values.Env.Network)
-	if malscanconfig.Values.Alert.DynamicRemoteHost == true {
-		fileReport.File.Tags = append(fileReport.File.Tags, "sen"+string(utils.ParseInstance(filename)[7]))
-	}
+	pending := plugins
+	for len(pending) > 0 {
 
-	fileReport.File.Tags = append(fileReport.File.Tags, "malscan")
+		var updating []Plugin
 
-	//Set timestamp of scan
-	fileReport.File.Date = time.Now().Format(time.RFC3339)
+		for _, plugin := range pending {
 
-	log.Infof("analyzed:%s:with:%s:infected:%t", filename, strings.Join(pluginsUsed, ","), detected)
+			slots <- struct{}{} //Wait for a free slot
 
-	//docker.Prune() //Clean docker system (NOT SAFE TO USE) - containers now removed invidually in container.go
-	// no but seriously using this could make a lot of people mad
+			g := gate(plugin.Name)
 
-	if malscanconfig.Values.Elasticsearch.Enabled == true {
-		elastic.Index(fileReport, &filename) //Post es results
-	}
+			entered := false
+			if ctx.Err() == nil {
+				if !g.enter(plugin.Name) {
+					<-slots
+					updating = append(updating, plugin)
+					continue
+				}
+				entered = true
+			}
+
+			wg.Add(1)
+			go func(plugin Plugin, g *pluginGate, entered bool) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				if entered {
+					defer g.leave()
+				}
+				run(plugin)
+			}(plugin, g, entered)
+		}
+
+		if len(updating) > 0 {
+			log.Debugf("waiting for the update of:%s:to finish", updating[0].Name)
+			select {
+			case <-gate(updating[0].Name).resumedCh(updating[0].Name):
+			case <-ctx.Done():
+			}
+		}
 
-	b, err := json.Marshal(fileReport)
-	if err != nil {
-		log.WithFields(log.Fields{"err": err}).Fatalf("failed to read %s", pluginFile)
+		pending = updating
 	}
-	log.Info(string(b))
 
+	wg.Wait()
 }
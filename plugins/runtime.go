@@ -0,0 +1,283 @@
+package plugins
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"malscan/core/docker"
+	"malscan/core/process"
+	"malscan/structs"
+
+	"github.com/pkg/errors"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains the runtimes plugins can be ran with
+
+*/
+
+const (
+	runtimeDocker = "docker"
+	runtimeExec   = "exec"
+)
+
+//defaultUpdateTimeout - How long an update container can run when the plugin does not set update_timeout
+const defaultUpdateTimeout = 30 * time.Minute
+
+//Output - Output of a plugin ran against a file, plugins print the same json to stdout whatever runtime they use
+type Output struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int64
+	ImageID  string //Image the plugin was ran from, only set by runtimes that run images
+}
+
+//ErrTimeout - Returned by a runtime when a plugin is stopped for running longer than its timeout
+var ErrTimeout = errors.New("plugin timed out")
+
+//ErrCancelled - Returned by a runtime when a plugin is stopped because the scan was cancelled
+var ErrCancelled = errors.New("plugin cancelled")
+
+//Runtime - Runs plugins against files
+type Runtime interface {
+	//Installed - Returns the plugins passed in that are ready to be ran
+	Installed(plugins []Plugin) []Plugin
+	//Run - Runs a plugin against a file in the filestore (or an absolute path), the plugin is stopped if ctx is cancelled
+	Run(ctx context.Context, plugin Plugin, filename *string) (Output, error)
+	//Signature - Returns a value that changes whenever the plugins engine or signatures change
+	Signature(plugin Plugin) (string, error)
+	//Version - Returns the engine and signature versions the plugin reports
+	Version(plugin Plugin) (structs.PluginVersion, error)
+}
+
+//runtimes - Every runtime a plugin can set in plugins.toml
+var runtimes = map[string]Runtime{
+	runtimeDocker: dockerRuntime{},
+	runtimeExec:   execRuntime{},
+	runtimeYara:   yaraRuntime{},
+	runtimeClamd:  clamdRuntime{},
+}
+
+//runtimeName - Returns the runtime set for the plugin, plugins are ran with docker unless set otherwise
+func (plugin Plugin) runtimeName() string {
+
+	if plugin.Runtime == "" {
+		return runtimeDocker
+	}
+
+	return plugin.Runtime
+}
+
+//runtime - Returns the runtime the plugin is ran with
+func (plugin Plugin) runtime() Runtime {
+
+	return runtimes[plugin.runtimeName()]
+}
+
+//timeout - Returns how long the plugin can run against a file, 0 for no timeout
+func (plugin Plugin) timeout() (time.Duration, error) {
+
+	if plugin.Timeout == "" {
+		return 0, nil
+	}
+
+	timeout, err := time.ParseDuration(plugin.Timeout)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid timeout for plugin: "+plugin.Name)
+	}
+
+	return timeout, nil
+}
+
+//updateTimeout - Returns how long the plugins update container can run before it is killed, updates always have a timeout
+//so a hung update can not hold scans with the plugin forever
+func (plugin Plugin) updateTimeout() (time.Duration, error) {
+
+	if plugin.UpdateTimeout == "" {
+		return defaultUpdateTimeout, nil
+	}
+
+	timeout, err := time.ParseDuration(plugin.UpdateTimeout)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid update_timeout for plugin: "+plugin.Name)
+	}
+	if timeout <= 0 {
+		return 0, errors.New("update_timeout must be more than 0 for plugin: " + plugin.Name)
+	}
+
+	return timeout, nil
+}
+
+//validate - Checks the plugin has a known runtime and the settings that runtime needs
+func (plugin Plugin) validate() error {
+
+	if plugin.runtime() == nil {
+		return errors.New("unknown runtime: " + plugin.Runtime + " for plugin: " + plugin.Name)
+	}
+
+	if _, err := plugin.timeout(); err != nil {
+		return err
+	}
+
+	if _, err := plugin.updateTimeout(); err != nil {
+		return err
+	}
+
+	if _, _, err := plugin.maxAges(); err != nil {
+		return err
+	}
+
+	switch plugin.runtimeName() {
+	case runtimeDocker:
+		if plugin.Image == "" {
+			return errors.New("no image set for docker plugin: " + plugin.Name)
+		}
+		if _, err := plugin.runOptions(); err != nil {
+			return err
+		}
+	case runtimeExec:
+		if plugin.Command == "" {
+			return errors.New("no command set for exec plugin: " + plugin.Name)
+		}
+	case runtimeYara:
+		if len(plugin.Rules) == 0 {
+			return errors.New("no rule directories set for yara plugin: " + plugin.Name)
+		}
+	case runtimeClamd:
+		if plugin.Address == "" {
+			return errors.New("no address set for clamd plugin: " + plugin.Name)
+		}
+	}
+
+	return nil
+}
+
+//dockerRuntime - Runs plugins as containers created from the plugins image
+type dockerRuntime struct{}
+
+//Installed - Returns the plugins whose image has been pulled
+func (dockerRuntime) Installed(plugins []Plugin) (installed []Plugin) {
+
+	images := docker.GetIntalledImages()
+
+	for _, plugin := range plugins {
+		if _, ok := findImage(images, plugin.Image); ok {
+			installed = append(installed, plugin)
+		}
+	}
+
+	return installed
+}
+
+//Run - Runs the plugins image as a container against the file
+func (dockerRuntime) Run(ctx context.Context, plugin Plugin, filename *string) (Output, error) {
+
+	options, _ := plugin.runOptions() //Options are validated when plugins are loaded
+	options.ScanID = scanID(ctx)
+
+	result, err := docker.RunContainerOnFile(ctx, plugin.Image, filename, options)
+	switch err {
+	case docker.ErrTimeout:
+		err = ErrTimeout
+	case docker.ErrCancelled:
+		err = ErrCancelled
+	}
+
+	return Output{Stdout: result.Stdout, Stderr: result.Stderr, ExitCode: result.ExitCode, ImageID: result.ImageID}, err
+}
+
+//Signature - Returns the ID of the plugins image, updating a plugin commits a new image
+func (dockerRuntime) Signature(plugin Plugin) (string, error) {
+
+	return docker.ImageID(plugin.Image)
+}
+
+//Version - Runs the plugins image with the version command instead of against a file
+func (dockerRuntime) Version(plugin Plugin) (structs.PluginVersion, error) {
+
+	options, _ := plugin.runOptions() //Options are validated when plugins are loaded
+	options.Plugin = plugin.Name
+
+	result, err := docker.RunContainerCommand(context.Background(), plugin.Image, []string{versionCommand}, options)
+	if err != nil {
+		return structs.PluginVersion{}, err
+	}
+
+	return parseVersion(result.Stdout)
+}
+
+//execRuntime - Runs plugins as a local binary or script, the sandbox settings do not apply to these plugins
+type execRuntime struct{}
+
+//Installed - Returns the plugins whose command can be found
+func (execRuntime) Installed(plugins []Plugin) (installed []Plugin) {
+
+	for _, plugin := range plugins {
+		if _, err := exec.LookPath(plugin.Command); err == nil {
+			installed = append(installed, plugin)
+		}
+	}
+
+	return installed
+}
+
+//Run - Runs the plugins command against the file
+func (execRuntime) Run(ctx context.Context, plugin Plugin, filename *string) (Output, error) {
+
+	timeout, _ := plugin.timeout() //Timeouts are validated when plugins are loaded
+
+	result, err := process.RunCommandOnFile(ctx, plugin.Command, filename, process.Options{
+		Args:    plugin.Args,
+		Env:     plugin.Env,
+		Timeout: timeout,
+	})
+	switch err {
+	case process.ErrTimeout:
+		err = ErrTimeout
+	case process.ErrCancelled:
+		err = ErrCancelled
+	}
+
+	return Output{Stdout: result.Stdout, Stderr: result.Stderr, ExitCode: result.ExitCode}, err
+}
+
+//Signature - Returns the size and modification time of the plugins command, the signatures of a local engine are not known
+//so only replacing the command itself is noticed
+func (execRuntime) Signature(plugin Plugin) (string, error) {
+
+	path, err := exec.LookPath(plugin.Command)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s:%d:%d", path, info.Size(), info.ModTime().UnixNano()), nil
+}
+
+//Version - Runs the plugins command with version in place of the path of the file
+func (execRuntime) Version(plugin Plugin) (structs.PluginVersion, error) {
+
+	timeout, _ := plugin.timeout() //Timeouts are validated when plugins are loaded
+
+	args := append(append([]string{}, plugin.Args...), versionCommand)
+
+	result, err := process.RunCommand(context.Background(), plugin.Command, args, versionCommand, process.Options{
+		Env:     plugin.Env,
+		Timeout: timeout,
+	})
+	if err != nil {
+		return structs.PluginVersion{}, err
+	}
+
+	return parseVersion(result.Stdout)
+}
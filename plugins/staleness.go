@@ -0,0 +1,292 @@
+package plugins
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	malscanconfig "malscan/config"
+	"malscan/core/alert"
+	"malscan/core/utils"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains functions related to noticing updatable plugins whose updates have stopped working
+
+*/
+
+const (
+	updatesFile = "updates.json"
+
+	//staleAlert - Kind of the operational alert raised when a plugin becomes stale
+	staleAlert = "stale_plugin"
+
+	defaultCheckInterval = time.Hour
+)
+
+//signatureDateLayouts - Layouts a reported signature_date is parsed with, clamd reports its database date like time.ANSIC
+var signatureDateLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02", time.ANSIC}
+
+//updateRecord - What is known about the updates of a plugin, kept on disk so it survives restarts and updates ran from the command line
+type updateRecord struct {
+	LastUpdate   time.Time `json:"last_update"`     //Last update that was kept, zero if it has never been updated
+	TrackedSince time.Time `json:"tracked_since"`   //Stands in for the last update of a plugin that has never been updated
+	Stale        string    `json:"stale,omitempty"` //Why the plugin is stale, set once the alert has been raised
+}
+
+var (
+	updateRecords      map[string]updateRecord //Records as last read or written, used by scans so they do not read the file
+	updateRecordsMutex sync.Mutex              //Held while the records are read, changed and written back so changes are not lost
+)
+
+//loadUpdateRecords - Reads the update records from disk, the mutex must be held. The file is read before every check and
+//save as updates ran from the command line write it from another process
+func loadUpdateRecords() map[string]updateRecord {
+
+	records := make(map[string]updateRecord)
+	updateRecords = records
+
+	data, err := ioutil.ReadFile(filepath.Join(utils.GetPlugDir(), updatesFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.WithFields(log.Fields{"err": err}).Errorf("reading:%s", updatesFile)
+		}
+		return records
+	}
+
+	if err := json.Unmarshal(data, &records); err != nil {
+		log.WithFields(log.Fields{"err": err}).Errorf("parsing:%s", updatesFile)
+	}
+
+	return records
+}
+
+//saveUpdateRecords - Writes the update records to disk, the mutex must be held
+func saveUpdateRecords(records map[string]updateRecord) {
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Errorf("marshaling:%s", updatesFile)
+		return
+	}
+
+	path := filepath.Join(utils.GetPlugDir(), updatesFile)
+	tmp := path + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		log.WithFields(log.Fields{"err": err}).Errorf("writing:%s", updatesFile)
+		return
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		log.WithFields(log.Fields{"err": err}).Errorf("writing:%s", updatesFile)
+	}
+}
+
+//recordUpdate - Records that an update of the plugin was kept
+func (plugin Plugin) recordUpdate() {
+
+	updateRecordsMutex.Lock()
+	defer updateRecordsMutex.Unlock()
+
+	records := loadUpdateRecords()
+
+	record := records[plugin.Name]
+	record.LastUpdate = time.Now().UTC()
+	records[plugin.Name] = record
+
+	saveUpdateRecords(records)
+}
+
+//maxAges - Returns the longest time since the plugins last update and the oldest signature date it can report,
+//the plugins own thresholds are used over the ones in the malscan config. 0 means the age is not checked
+func (plugin Plugin) maxAges() (update time.Duration, signature time.Duration, err error) {
+
+	updateAge := malscanconfig.Values.Staleness.MaxUpdateAge
+	if plugin.MaxUpdateAge != "" {
+		updateAge = plugin.MaxUpdateAge
+	}
+
+	signatureAge := malscanconfig.Values.Staleness.MaxSignatureAge
+	if plugin.MaxSignatureAge != "" {
+		signatureAge = plugin.MaxSignatureAge
+	}
+
+	if updateAge != "" {
+		if update, err = utils.ParseAge(updateAge); err != nil {
+			return 0, 0, errors.Wrap(err, "invalid max_update_age for plugin: "+plugin.Name)
+		}
+	}
+
+	if signatureAge != "" {
+		if signature, err = utils.ParseAge(signatureAge); err != nil {
+			return 0, 0, errors.Wrap(err, "invalid max_signature_age for plugin: "+plugin.Name)
+		}
+	}
+
+	return update, signature, nil
+}
+
+//stalenessOf - Returns why the plugin is stale, or an empty string when it is not. The record passed in is the plugins update record
+func (plugin Plugin) stalenessOf(record updateRecord, now time.Time) string {
+
+	maxUpdate, maxSignature, _ := plugin.maxAges() //Ages are validated when plugins are loaded
+
+	lastUpdate := record.LastUpdate
+	if lastUpdate.IsZero() {
+		lastUpdate = record.TrackedSince
+	}
+
+	if maxUpdate > 0 && now.Sub(lastUpdate) > maxUpdate {
+		if record.LastUpdate.IsZero() {
+			return "not updated since malscan started tracking it at " + lastUpdate.Format(time.RFC3339)
+		}
+		return "last updated at " + lastUpdate.Format(time.RFC3339) + ", longer ago than " + maxUpdate.String()
+	}
+
+	if maxSignature > 0 {
+		if date, ok := parseSignatureDate(versionOf(plugin.Name).SignatureDate); ok && now.Sub(date) > maxSignature {
+			return "signatures dated " + date.Format(time.RFC3339) + " are older than " + maxSignature.String()
+		}
+	}
+
+	return ""
+}
+
+//parseSignatureDate - Parses the signature date a plugin reported, false is returned when it did not report one that can be parsed
+func parseSignatureDate(date string) (time.Time, bool) {
+
+	if date == "" {
+		return time.Time{}, false
+	}
+
+	for _, layout := range signatureDateLayouts {
+		if parsed, err := time.Parse(layout, date); err == nil {
+			return parsed, true
+		}
+	}
+
+	log.Debugf("unknown signature date format:%s", date)
+
+	return time.Time{}, false
+}
+
+//checkStaleness - Works out whether the plugin is stale, an operational alert is raised when it becomes stale and
+//a message is logged once it is up to date again. Only updatable plugins are checked
+func (plugin Plugin) checkStaleness() {
+
+	if malscanconfig.Values.Staleness.Enabled != true || plugin.Updatable != true {
+		return
+	}
+
+	updateRecordsMutex.Lock()
+
+	records := loadUpdateRecords()
+
+	now := time.Now().UTC()
+
+	record, ok := records[plugin.Name]
+	if !ok {
+		record.TrackedSince = now
+	}
+
+	reason := plugin.stalenessOf(record, now)
+	raise := reason != "" && record.Stale == ""
+
+	if reason == "" && record.Stale != "" {
+		log.Infof("plugin:%s:is up to date again", plugin.Name)
+	}
+
+	if !ok || record.Stale != reason {
+		record.Stale = reason
+		records[plugin.Name] = record
+		saveUpdateRecords(records)
+	}
+
+	updateRecordsMutex.Unlock()
+
+	//The alert is sent once the records are unlocked as sending it can be slow
+	if raise {
+		log.Warnf("plugin:%s:is stale:%s", plugin.Name, reason)
+		alert.Operational(staleAlert, plugin.Name, reason)
+	}
+}
+
+//staleReason - Returns why the plugin is stale, or an empty string when it is not or staleness is not checked.
+//The records last read are used, they are read again on every staleness check
+func staleReason(name string) string {
+
+	if malscanconfig.Values.Staleness.Enabled != true {
+		return ""
+	}
+
+	updateRecordsMutex.Lock()
+	defer updateRecordsMutex.Unlock()
+
+	if updateRecords == nil {
+		loadUpdateRecords()
+	}
+
+	return updateRecords[name].Stale
+}
+
+//checkInterval - Returns how often plugins are checked for staleness
+func checkInterval() time.Duration {
+
+	if malscanconfig.Values.Staleness.CheckInterval == "" {
+		return defaultCheckInterval
+	}
+
+	interval, err := utils.ParseAge(malscanconfig.Values.Staleness.CheckInterval)
+	if err != nil || interval <= 0 {
+		log.WithFields(log.Fields{"err": err}).Errorf("invalid staleness check interval:%s:using:%s", malscanconfig.Values.Staleness.CheckInterval, defaultCheckInterval)
+		return defaultCheckInterval
+	}
+
+	return interval
+}
+
+//StartStalenessMonitor - Responsible for checking every enabled updatable plugin for staleness straight away and then on
+//the check interval in the staleness section of the malscan config, nothing is done when staleness is not enabled.
+//The function returned stops the monitor
+func (pconfig PluginConfig) StartStalenessMonitor() (stop func()) {
+
+	if malscanconfig.Values.Staleness.Enabled != true {
+		return func() {}
+	}
+
+	check := func() {
+		for _, plugin := range pconfig.GetEnabledPlugins() {
+			plugin.checkStaleness()
+		}
+	}
+
+	check()
+
+	ticker := time.NewTicker(checkInterval())
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				check()
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
@@ -0,0 +1,100 @@
+package plugins
+
+import (
+	"context"
+	"time"
+
+	"malscan/structs"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains functions related to recording how each plugin ran against a file
+
+*/
+
+const (
+	stderrExcerptSize = 1024 //Bytes of a plugins stderr kept in the report
+)
+
+//runPlugin - Responsible for running a single plugin against a file and recording how it went
+//the plugins stdout is only returned when the plugin ran, parsing the output is left to the caller.
+//Scans enter the plugins gate before running it (see runLimited), updates run it while holding the gate
+func runPlugin(ctx context.Context, plugin Plugin, filename *string) ([]byte, structs.PluginStatus) {
+
+	start := time.Now()
+
+	result, err := plugin.runtime().Run(ctx, plugin, filename) //Run plugin with its runtime
+
+	status := structs.PluginStatus{
+		State:      structs.StateOK,
+		ExitCode:   result.ExitCode,
+		DurationMs: time.Since(start).Milliseconds(),
+		Stderr:     stderrExcerpt(result.Stderr),
+		ImageID:    result.ImageID,
+	}
+
+	switch {
+	case err == ErrTimeout:
+		status.State = structs.StateTimeout
+		status.Error = err.Error()
+	case err == ErrCancelled:
+		status.State = structs.StateCancelled
+		status.Error = err.Error()
+	case err != nil:
+		status.State = structs.StateError
+		status.Error = err.Error()
+	}
+
+	//Versions a plugin prints with its result are newer than the ones collected when it was last started or updated, so they replace them
+	status.PluginVersion = versionOf(plugin.Name)
+	if status.State == structs.StateOK {
+		if reported, err := parseVersion(result.Stdout); err == nil {
+			status.PluginVersion = status.PluginVersion.Merge(reported)
+		}
+	}
+
+	if reason := staleReason(plugin.Name); reason != "" && plugin.Updatable {
+		status.Stale = true
+		status.StaleReason = reason
+	}
+
+	return result.Stdout, status
+}
+
+//failed - Marks a plugin status as errored, used when a plugin ran but its output could not be used
+func failed(status structs.PluginStatus, plugName string, err error) structs.PluginStatus {
+
+	log.WithFields(log.Fields{"err": err}).Errorf("plugin:%s:produced unusable output", plugName)
+
+	status.State = structs.StateError
+	status.Error = err.Error()
+
+	return status
+}
+
+//skipped - Returns the status of each plugin skipped along with the reason it was skipped
+func skipped(reasons map[string]string) map[string]structs.PluginStatus {
+
+	statuses := make(map[string]structs.PluginStatus)
+
+	for name, reason := range reasons {
+		statuses[name] = structs.PluginStatus{State: structs.StateSkipped, Reason: reason}
+	}
+
+	return statuses
+}
+
+//stderrExcerpt - Returns the tail of a plugins stderr
+func stderrExcerpt(stderr []byte) string {
+
+	if len(stderr) > stderrExcerptSize {
+		stderr = stderr[len(stderr)-stderrExcerptSize:]
+	}
+
+	return string(stderr)
+}
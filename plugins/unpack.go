@@ -0,0 +1,171 @@
+package plugins
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+
+	malscanconfig "malscan/config"
+	"malscan/core/unpack"
+	"malscan/core/utils"
+	file "malscan/core/utils/file"
+	"malscan/elastic"
+	"malscan/structs"
+
+	units "github.com/docker/go-units"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains functions related to scanning the files unpacked from archives
+
+*/
+
+//verdictRank - Order verdicts are rolled up in, only malicious and suspicious children can raise the verdict of an archive
+var verdictRank = map[string]int{
+	structs.VerdictSuspicious: 1,
+	structs.VerdictMalicious:  2,
+}
+
+//scanChildren - Responsible for unpacking an archive and scanning every file inside it, each child report links to the sha256 of
+//the archive it came from and the verdict of the archive is rolled up from its children
+func (pconfig PluginConfig) scanChildren(ctx context.Context, filename string, fileReport *structs.FullFileReport, limit int) {
+
+	if malscanconfig.Values.Unpack.Enabled != true {
+		return
+	}
+
+	onDisk := file.Path(filename)
+	if unpack.Format(onDisk) == "" {
+		return
+	}
+
+	limits, err := unpackLimits()
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("loading unpack limits")
+		fileReport.File.UnpackError = err.Error()
+		return
+	}
+
+	dir, err := ioutil.TempDir(utils.GetScratchDir(), "unpack-")
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Errorf("creating unpack dir for:%s", filename)
+		fileReport.File.UnpackError = err.Error()
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	entries, archives, err := unpack.Unpack(onDisk, dir, limits, malscanconfig.Values.Unpack.Passwords)
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Warnf("unpacking:%s:stopped after:%d:files", filename, len(entries))
+		fileReport.File.UnpackError = err.Error()
+	}
+
+	//Parents are always unpacked before their children so their sha256 is known by the time a child is scanned
+	sha256s := map[string]string{onDisk: fileReport.File.Sha256}
+	children := make(map[string]int) //Index of each child report by its path on disk
+
+	for _, entry := range entries {
+
+		if ctx.Err() != nil {
+			return //The archive is scanned again in full so the remaining children are left
+		}
+
+		entryPath := entry.Path
+
+		child := pconfig.scanFile(ctx, entryPath, path.Base(entry.Name), filename+"/"+entry.Name, limit)
+		child.File.Parent = sha256s[entry.Parent]
+		child.File.Path = entry.Name
+		child.File.Tags = fileReport.File.Tags
+
+		sha256s[entryPath] = child.File.Sha256
+
+		rollUp(fileReport, child)
+
+		if malscanconfig.Values.Elasticsearch.Enabled == true && ctx.Err() == nil {
+			elastic.Index(child, &entryPath) //Post es results for the child while it is still on disk
+		}
+
+		children[entryPath] = len(fileReport.Children)
+		fileReport.Children = append(fileReport.Children, child)
+	}
+
+	for _, archive := range archives {
+		if archive.Path == onDisk {
+			encrypted(fileReport, archive)
+			continue
+		}
+		if i, ok := children[archive.Path]; ok {
+			encrypted(&fileReport.Children[i], archive)
+		}
+	}
+}
+
+//encrypted - Records the password that opened an archive, an archive with files that could not be opened cannot be clean
+func encrypted(fileReport *structs.FullFileReport, archive unpack.Archive) {
+
+	fileReport.File.Password = archive.Password
+
+	if len(archive.Locked) == 0 {
+		return
+	}
+
+	log.Warnf("could not open:%d:encrypted files in:%s", len(archive.Locked), fileReport.File.Name)
+
+	fileReport.File.Malware.Reason = structs.ReasonEncrypted
+	if fileReport.File.Malware.Verdict == structs.VerdictClean {
+		fileReport.File.Malware.Verdict = structs.VerdictUnknown
+	}
+}
+
+//rollUp - Raises the verdict of an archive to that of a malicious or suspicious child and adds the childs detections to the archive
+func rollUp(fileReport *structs.FullFileReport, child structs.FullFileReport) {
+
+	if verdictRank[child.File.Malware.Verdict] == 0 {
+		return
+	}
+
+	for _, result := range child.File.Malware.Results {
+		found := false
+		for _, existing := range fileReport.File.Malware.Results {
+			if existing == result {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fileReport.File.Malware.Results = append(fileReport.File.Malware.Results, result)
+		}
+	}
+
+	if verdictRank[child.File.Malware.Verdict] > verdictRank[fileReport.File.Malware.Verdict] {
+		fileReport.File.Malware.Verdict = child.File.Malware.Verdict
+		fileReport.File.Malware.Infected = child.File.Malware.Verdict == structs.VerdictMalicious
+		fileReport.File.Malware.RolledUp = true
+	}
+}
+
+//unpackLimits - Builds the unpack limits from the malscan config, limits that are not set use the unpack defaults
+func unpackLimits() (unpack.Limits, error) {
+
+	limits := unpack.Limits{
+		MaxDepth:   malscanconfig.Values.Unpack.MaxDepth,
+		MaxEntries: malscanconfig.Values.Unpack.MaxEntries,
+		MaxRatio:   malscanconfig.Values.Unpack.MaxRatio,
+	}
+
+	if malscanconfig.Values.Unpack.MaxTotalSize != "" {
+		size, err := units.RAMInBytes(malscanconfig.Values.Unpack.MaxTotalSize)
+		if err != nil {
+			return limits, errors.Wrap(err, "invalid max_total_size")
+		}
+		limits.MaxTotalSize = size
+	}
+
+	return limits, nil
+}
@@ -0,0 +1,61 @@
+package plugins
+
+import (
+	"path/filepath"
+	"testing"
+
+	"malscan/core/unpack"
+	"malscan/structs"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Tests recording the password of an encrypted archive and the verdict of one that could not be opened
+
+*/
+
+func TestEncrypted(t *testing.T) {
+
+	tests := []struct {
+		archive   string
+		passwords []string
+		verdict   string //Verdict of the archive before its encrypted files are recorded
+		password  string
+		want      string
+		reason    string
+	}{
+		{"zipcrypto.zip", []string{"wrong", "infected"}, structs.VerdictClean, "infected", structs.VerdictClean, ""},
+		{"aes256.zip", []string{"infected"}, structs.VerdictClean, "infected", structs.VerdictClean, ""},
+		{"zipcrypto.zip", []string{"wrong"}, structs.VerdictClean, "", structs.VerdictUnknown, structs.ReasonEncrypted},
+		{"aes256.zip", nil, structs.VerdictClean, "", structs.VerdictUnknown, structs.ReasonEncrypted},
+		{"aes256-stored.zip", []string{"wrong"}, structs.VerdictMalicious, "", structs.VerdictMalicious, structs.ReasonEncrypted},
+	}
+
+	for _, test := range tests {
+
+		filename := filepath.Join("..", "core", "unpack", "testdata", test.archive)
+
+		_, archives, err := unpack.Unpack(filename, t.TempDir(), unpack.Limits{}, test.passwords)
+		if err != nil {
+			t.Fatalf("Unpack of %s: %v", test.archive, err)
+		}
+		if len(archives) != 1 {
+			t.Fatalf("Unpack of %s recorded %d archives, want 1", test.archive, len(archives))
+		}
+
+		var report structs.FullFileReport
+		report.File.Malware.Verdict = test.verdict
+
+		encrypted(&report, archives[0])
+
+		if report.File.Password != test.password {
+			t.Errorf("%s with %v: password = %q, want %q", test.archive, test.passwords, report.File.Password, test.password)
+		}
+		if report.File.Malware.Verdict != test.want || report.File.Malware.Reason != test.reason {
+			t.Errorf("%s with %v: verdict = %q %q, want %q %q", test.archive, test.passwords,
+				report.File.Malware.Verdict, report.File.Malware.Reason, test.want, test.reason)
+		}
+	}
+}
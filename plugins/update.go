@@ -0,0 +1,198 @@
+package plugins
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"time"
+
+	malscanconfig "malscan/config"
+	"malscan/core/docker"
+	"malscan/core/schedule"
+	"malscan/core/utils"
+	"malscan/structs"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains functions related to updating plugins, self testing them and rolling back updates that break them
+
+*/
+
+//Outcomes of a plugin update
+const (
+	updateSuccess    = "success"
+	updateFailure    = "failure"
+	updateRolledBack = "rolled back"
+)
+
+//eicar - The EICAR anti-virus test file, split so the source itself is not detected
+var eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$` + `EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+//canUpdate - Tests whether the plugins runtime knows how to update it
+func (plugin Plugin) canUpdate() bool {
+
+	switch plugin.runtimeName() {
+	case runtimeDocker, runtimeClamd:
+		return true
+	}
+
+	return false
+}
+
+//update - Updates a plugin with its runtime, container plugins run their update command and are committed back to their image,
+//clamd plugins are asked to reload their signature database. When self_test is set the plugin scans the EICAR test file before
+//and after the update, a container plugin that passed before and fails after is rolled back to its :previous image.
+//Scans with the plugin are paused from before the first self-test until the update is either kept or rolled back,
+//the plugins version is collected again before scans resume. An update that is kept is recorded.
+//Staleness is checked once scans have resumed so a slow alert does not hold them
+func (plugin Plugin) update() (outcome string) {
+
+	defer plugin.checkStaleness()
+	defer pause(plugin)()
+	defer plugin.collectVersion()
+	defer func() {
+		if outcome == updateSuccess {
+			plugin.recordUpdate()
+		}
+	}()
+
+	selfTest := malscanconfig.Values.Updates.SelfTest
+
+	var before error
+	if selfTest {
+		before = plugin.selfTest()
+	}
+
+	outcome = updateSuccess
+
+	if plugin.runtimeName() == runtimeClamd {
+		client, err := clamdRuntime{}.client(plugin)
+		if err == nil {
+			err = client.Reload()
+		}
+		if err != nil {
+			log.WithFields(log.Fields{"err": err}).Errorf("reloading clamd for:%s", plugin.Name)
+			return updateFailure
+		}
+	} else {
+		timeout, _ := plugin.updateTimeout() //Validated when plugins are loaded
+		outcome = docker.RunContainerUpdate(plugin.Image, plugin.Name, timeout)
+	}
+
+	if outcome != updateSuccess || !selfTest {
+		return outcome
+	}
+
+	if before != nil {
+		log.WithFields(log.Fields{"err": before}).Warnf("plugin:%s:failed its self-test before updating, the update is kept", plugin.Name)
+		return outcome
+	}
+
+	after := plugin.selfTest()
+	if after == nil {
+		log.Infof("plugin:%s:passed its self-test after updating", plugin.Name)
+		return outcome
+	}
+
+	log.WithFields(log.Fields{"err": after}).Errorf("plugin:%s:failed its self-test after updating", plugin.Name)
+
+	//clamd keeps its own databases so only container plugins can be rolled back
+	if plugin.runtimeName() != runtimeDocker {
+		return updateFailure
+	}
+
+	if err := docker.RestorePrevious(plugin.Image); err != nil {
+		log.WithFields(log.Fields{"err": err}).Errorf("rolling back:%s", plugin.Name)
+		return updateFailure
+	}
+
+	return updateRolledBack
+}
+
+//selfTest - Runs the plugin against the EICAR test file, av plugins must detect it and er plugins must run cleanly
+func (plugin Plugin) selfTest() error {
+
+	f, err := ioutil.TempFile(utils.GetScratchDir(), "selftest-")
+	if err != nil {
+		return errors.Wrap(err, "error while creating self-test file")
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(eicar)
+	f.Close()
+	if err != nil {
+		return errors.Wrap(err, "error while writing self-test file")
+	}
+
+	filename := f.Name()
+
+	output, status := runPlugin(context.Background(), plugin, &filename)
+	if status.State != structs.StateOK {
+		return errors.New("self-test " + status.State + ": " + status.Error)
+	}
+
+	if plugin.Category != dectection {
+		return nil
+	}
+
+	infected, err := testInfected(output, &plugin.Name)
+	if err != nil {
+		return errors.Wrap(err, "error while parsing self-test result")
+	}
+	if !infected {
+		return errors.New("did not detect the EICAR test file")
+	}
+
+	return nil
+}
+
+//StartUpdater - Responsible for updating every enabled plugin on the schedule in the updates section of the malscan config,
+//nothing is done when updates are not enabled. The function returned stops the updater
+func (pconfig PluginConfig) StartUpdater() (stop func()) {
+
+	if malscanconfig.Values.Updates.Enabled != true {
+		return func() {}
+	}
+
+	sched, err := schedule.Parse(malscanconfig.Values.Updates.Schedule)
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("not scheduling plugin updates")
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			next := sched.Next(time.Now())
+			if next.IsZero() {
+				log.Errorf("update schedule:%s:never runs", malscanconfig.Values.Updates.Schedule)
+				return
+			}
+
+			log.Infof("next plugin update at:%s", next.Format(time.RFC3339))
+
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-timer.C:
+				for ran, statuses := range pconfig.RunPluginUpdateAll() {
+					for name, status := range statuses {
+						log.Infof("scheduled update at:%s:plugin:%s:%s", ran, name, status)
+					}
+				}
+			case <-done:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
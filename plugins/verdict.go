@@ -0,0 +1,126 @@
+package plugins
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	malscanconfig "malscan/config"
+	"malscan/structs"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains functions related to deciding the verdict for a file from its av results
+
+*/
+
+const (
+	defaultWeight = 1.0
+)
+
+//verdictPolicy - The verdict section of the malscan config with defaults filled in
+type verdictPolicy struct {
+	minDetections int
+	score         float64
+	weights       map[string]float64
+	sufficient    map[string]bool
+}
+
+//loadVerdictPolicy - Reads the verdict policy from the malscan config, by default a single detection is malicious
+func loadVerdictPolicy() verdictPolicy {
+
+	policy := verdictPolicy{
+		minDetections: malscanconfig.Values.Verdict.MinDetections,
+		score:         malscanconfig.Values.Verdict.Score,
+		weights:       malscanconfig.Values.Verdict.Weights,
+		sufficient:    make(map[string]bool),
+	}
+
+	if policy.minDetections < 1 {
+		policy.minDetections = 1
+	}
+	if policy.score <= 0 {
+		policy.score = defaultWeight
+	}
+	for _, name := range malscanconfig.Values.Verdict.Sufficient {
+		policy.sufficient[name] = true
+	}
+
+	return policy
+}
+
+//weight - Returns the weight of a plugins detection
+func (policy verdictPolicy) weight(plugName string) float64 {
+
+	if weight, ok := policy.weights[plugName]; ok {
+		return weight
+	}
+
+	return defaultWeight
+}
+
+//String - Describes the policy so it can be recorded in reports
+func (policy verdictPolicy) String() string {
+
+	var sufficient []string
+	for name := range policy.sufficient {
+		sufficient = append(sufficient, name)
+	}
+	sort.Strings(sufficient)
+
+	return fmt.Sprintf("min_detections=%d score>=%.2f sufficient=[%s]", policy.minDetections, policy.score, strings.Join(sufficient, ","))
+}
+
+//evaluate - Works out the verdict and score for a file from the status of each av plugin and the plugins that detected it
+//a file is malicious when one of its detections is sufficient on its own or when both the detection count and the score
+//reach the policy, any other detection is suspicious. A file is only clean if at least one av plugin produced a usable result
+func (policy verdictPolicy) evaluate(statuses map[string]structs.PluginStatus, detected []string, avPlugins []Plugin) (verdict string, score float64) {
+
+	ran := 0
+	for _, plugin := range avPlugins {
+		if statuses[plugin.Name].State == structs.StateOK {
+			ran++
+		}
+	}
+
+	sufficient := false
+	for _, name := range detected {
+		score += policy.weight(name)
+		if policy.sufficient[name] {
+			sufficient = true
+		}
+	}
+
+	switch {
+	case ran == 0:
+		return structs.VerdictUnknown, score
+	case sufficient:
+		return structs.VerdictMalicious, score
+	case len(detected) >= policy.minDetections && score >= policy.score:
+		return structs.VerdictMalicious, score
+	case len(detected) > 0:
+		return structs.VerdictSuspicious, score
+	}
+
+	return structs.VerdictClean, score
+}
+
+//shouldAlert - Tests whether a verdict generates an alert
+func shouldAlert(verdict string) bool {
+
+	alertOn := malscanconfig.Values.Verdict.Alert
+	if len(alertOn) == 0 {
+		alertOn = []string{structs.VerdictMalicious}
+	}
+
+	for _, v := range alertOn {
+		if v == verdict {
+			return true
+		}
+	}
+
+	return false
+}
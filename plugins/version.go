@@ -0,0 +1,72 @@
+package plugins
+
+import (
+	"sync"
+
+	"malscan/structs"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains functions related to collecting the engine and signature versions plugins report
+
+*/
+
+//versionCommand - Command plugins are ran with instead of a file to print their version json
+const versionCommand = "version"
+
+//versions - Last version each plugin reported, recorded with every result the plugin produces
+var (
+	versions      = make(map[string]structs.PluginVersion)
+	versionsMutex sync.RWMutex
+)
+
+//CollectVersions - Responsible for asking every enabled and installed plugin for its engine and signature versions,
+//plugins are asked in parallel as container plugins each start a container
+func (pconfig PluginConfig) CollectVersions() {
+
+	var wg sync.WaitGroup
+
+	for _, plugin := range pconfig.GetEnabledInstalledPlugins() {
+		wg.Add(1)
+		go func(plugin Plugin) {
+			defer wg.Done()
+			plugin.collectVersion()
+		}(plugin)
+	}
+
+	wg.Wait()
+}
+
+//collectVersion - Asks the plugin for its version, a plugin that does not report one no longer has a version recorded
+//so results are never labelled with a version from before an update
+func (plugin Plugin) collectVersion() {
+
+	version, err := plugin.runtime().Version(plugin)
+
+	versionsMutex.Lock()
+	defer versionsMutex.Unlock()
+
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Warnf("plugin:%s:did not report its version", plugin.Name)
+		delete(versions, plugin.Name)
+		return
+	}
+
+	log.Infof("plugin:%s:engine:%s:signatures:%s:%s", plugin.Name, version.EngineVersion, version.SignatureVersion, version.SignatureDate)
+
+	versions[plugin.Name] = version
+}
+
+//versionOf - Returns the last version the plugin reported
+func versionOf(name string) structs.PluginVersion {
+
+	versionsMutex.RLock()
+	defer versionsMutex.RUnlock()
+
+	return versions[name]
+}
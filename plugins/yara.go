@@ -0,0 +1,213 @@
+package plugins
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	file "malscan/core/utils/file"
+	"malscan/core/yara"
+	"malscan/structs"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+Author: Liam Hellend
+Email: liamhellend@gmail.com
+
+Purpose: Contains the built-in yara runtime, files are scanned in-process instead of in a container
+
+*/
+
+const (
+	runtimeYara    = "yara"
+	yaraRetryAfter = time.Minute //Time before rules that failed to compile are compiled again
+)
+
+var yaraMutex sync.Mutex
+var yaraEngines = make(map[string]*yara.Engine) //Compiled rules for each yara plugin, keyed by plugin name
+var yaraErrors = make(map[string]yaraError)     //Plugins whose rules failed to compile, so they are not recompiled for every file
+
+//yaraError - Why a plugins rules failed to compile and when they are compiled again
+type yaraError struct {
+	err     error
+	retryAt time.Time //Zero when compiling again can not help, such as malscan being built without yara
+}
+
+//yaraOutput - The analysis json written by the yara runtime, the same contract container plugins follow
+type yaraOutput struct {
+	Analysis struct {
+		Infected bool         `json:"infected"`
+		Result   string       `json:"result"`
+		Matches  []yara.Match `json:"matches"`
+	} `json:"analysis"`
+}
+
+//yaraRuntime - Scans files with rules compiled once from the plugins rule directories
+type yaraRuntime struct{}
+
+//engine - Returns the compiled rules for a plugin, compiling them the first time the plugin is used.
+//Rules that failed to compile are compiled again once yaraRetryAfter has passed, so a rule directory that was missing
+//or broken at startup is picked up once it is fixed
+func (yaraRuntime) engine(plugin Plugin) (*yara.Engine, error) {
+
+	yaraMutex.Lock()
+	defer yaraMutex.Unlock()
+
+	if engine, ok := yaraEngines[plugin.Name]; ok {
+		return engine, nil
+	}
+	if failed, ok := yaraErrors[plugin.Name]; ok && (failed.retryAt.IsZero() || time.Now().Before(failed.retryAt)) {
+		return nil, failed.err
+	}
+
+	engine, err := yara.NewEngine(plugin.Rules)
+	if err != nil {
+		failed := yaraError{err: errors.Wrap(err, "error while loading yara rules for plugin: "+plugin.Name)}
+		if errors.Cause(err) != yara.ErrUnsupported {
+			failed.retryAt = time.Now().Add(yaraRetryAfter)
+		}
+		log.WithFields(log.Fields{"err": failed.err}).Errorf("yara plugin:%s:is unavailable", plugin.Name)
+		yaraErrors[plugin.Name] = failed
+		return nil, failed.err
+	}
+
+	if _, ok := yaraErrors[plugin.Name]; ok {
+		log.Infof("yara plugin:%s:is available again", plugin.Name)
+		delete(yaraErrors, plugin.Name)
+	}
+
+	yaraEngines[plugin.Name] = engine
+
+	return engine, nil
+}
+
+//Installed - Returns the plugins whose rules compiled
+func (runtime yaraRuntime) Installed(plugins []Plugin) (installed []Plugin) {
+
+	for _, plugin := range plugins {
+		if _, err := runtime.engine(plugin); err == nil {
+			installed = append(installed, plugin)
+		}
+	}
+
+	return installed
+}
+
+//Run - Scans the file with the plugins rules, a scan that has started is not interrupted by ctx as it is bounded by the plugins timeout
+func (runtime yaraRuntime) Run(ctx context.Context, plugin Plugin, filename *string) (Output, error) {
+
+	if ctx.Err() != nil {
+		return Output{ExitCode: -1}, ErrCancelled
+	}
+
+	engine, err := runtime.engine(plugin)
+	if err != nil {
+		return Output{ExitCode: -1}, err
+	}
+
+	timeout, _ := plugin.timeout() //Timeouts are validated when plugins are loaded
+
+	matches, err := engine.ScanFile(file.Path(*filename), timeout)
+	if err != nil {
+		return Output{ExitCode: -1}, err
+	}
+
+	var output yaraOutput
+	var rules []string
+
+	for _, match := range matches {
+		rules = append(rules, match.Rule)
+	}
+
+	output.Analysis.Infected = len(matches) > 0
+	output.Analysis.Result = strings.Join(rules, ",")
+	output.Analysis.Matches = matches
+
+	stdout, err := json.Marshal(output)
+	if err != nil {
+		return Output{ExitCode: -1}, errors.Wrap(err, "error while marshaling yara matches")
+	}
+
+	return Output{Stdout: stdout}, nil
+}
+
+//Signature - Returns a fingerprint of the plugins rule files, the engine recompiles whenever a rule file changes
+func (yaraRuntime) Signature(plugin Plugin) (string, error) {
+
+	h := sha256.New()
+
+	for _, dir := range plugin.Rules {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				fmt.Fprintf(h, "%s:%d:%d\n", path, info.Size(), info.ModTime().UnixNano())
+			}
+			return nil
+		})
+		if err != nil {
+			return "", errors.Wrap(err, "error while reading yara rules for plugin: "+plugin.Name)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+//Version - Returns the rule fingerprint as the signature version and the modification time of the newest rule file
+//as the signature date, the engine is linked into malscan so it has no version of its own
+func (runtime yaraRuntime) Version(plugin Plugin) (structs.PluginVersion, error) {
+
+	signature, err := runtime.Signature(plugin)
+	if err != nil {
+		return structs.PluginVersion{}, err
+	}
+
+	var newest time.Time
+
+	for _, dir := range plugin.Rules {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && info.ModTime().After(newest) {
+				newest = info.ModTime()
+			}
+			return nil
+		})
+		if err != nil {
+			return structs.PluginVersion{}, errors.Wrap(err, "error while reading yara rules for plugin: "+plugin.Name)
+		}
+	}
+
+	version := structs.PluginVersion{SignatureVersion: signature[:12]}
+	if !newest.IsZero() {
+		version.SignatureDate = newest.UTC().Format(time.RFC3339)
+	}
+
+	return version, nil
+}
+
+//startYaraEngines - Compiles the rules for every enabled yara plugin so they are ready before the first file arrives
+func (pconfig PluginConfig) startYaraEngines() {
+
+	var enabled []Plugin
+
+	for _, plugin := range pconfig.GetEnabledPlugins() {
+		if plugin.runtimeName() == runtimeYara {
+			enabled = append(enabled, plugin)
+		}
+	}
+
+	yaraRuntime{}.Installed(enabled)
+}
@@ -2,30 +2,105 @@ package structs
 
 import "encoding/json"
 
+//Plugin states used in a reports status block
+const (
+	StateOK        = "ok"        //Plugin ran and its output was parsed
+	StateError     = "error"     //Plugin failed to run or its output could not be parsed
+	StateTimeout   = "timeout"   //Plugin was killed for running longer than its timeout
+	StateSkipped   = "skipped"   //Plugin was not ran against the file
+	StateCancelled = "cancelled" //Plugin was stopped because malscan was shutting down
+)
+
+//Overall verdicts for a file
+const (
+	VerdictMalicious  = "malicious"  //Detections met the verdict policy
+	VerdictSuspicious = "suspicious" //At least one av plugin detected malware but the verdict policy was not met
+	VerdictClean      = "clean"      //At least one av plugin ran and none detected malware
+	VerdictUnknown    = "unknown"    //No av plugin produced a usable result
+)
+
+//Reasons given alongside a verdict
+const (
+	ReasonEncrypted = "encrypted, could not open" //An archive has encrypted files none of the configured passwords could open
+)
+
 //FullFileReport - Used to fill in information for a file to be
 //sent off for alerting and elasticsearch indexing
 type FullFileReport struct {
-	File fileinfo `structs:"file" json:"file"`
+	File     fileinfo         `structs:"file" json:"file"`
+	Children []FullFileReport `structs:"children" json:"children,omitempty"` //Reports for the files unpacked from an archive
+}
+
+//PluginStatus - How running a single plugin against the file went
+type PluginStatus struct {
+	State       string `structs:"state" json:"state"`
+	ExitCode    int64  `structs:"exit_code" json:"exit_code"`
+	DurationMs  int64  `structs:"duration_ms" json:"duration_ms"`
+	Stderr      string `structs:"stderr" json:"stderr,omitempty"` //Tail of the plugins stderr
+	Error       string `structs:"error" json:"error,omitempty"`
+	Reason      string `structs:"reason" json:"reason,omitempty"`             //Why the plugin was skipped
+	ImageID     string `structs:"image_id" json:"image_id,omitempty"`         //Image the result was produced by, for plugins ran as containers
+	Stale       bool   `structs:"stale" json:"stale,omitempty"`               //The plugin is behind on updates, its result may miss new malware
+	StaleReason string `structs:"stale_reason" json:"stale_reason,omitempty"` //Why the plugin is stale
+	PluginVersion
+}
+
+//PluginVersion - Engine build and signature version a plugin reports, used to explain why a verdict changed between scans
+type PluginVersion struct {
+	EngineVersion    string `structs:"engine_version" json:"engine_version,omitempty"`
+	SignatureVersion string `structs:"signature_version" json:"signature_version,omitempty"`
+	SignatureDate    string `structs:"signature_date" json:"signature_date,omitempty"`
+}
+
+//Merge - Returns the version with every field set in newer replacing the same field
+func (version PluginVersion) Merge(newer PluginVersion) PluginVersion {
+
+	if newer.EngineVersion != "" {
+		version.EngineVersion = newer.EngineVersion
+	}
+	if newer.SignatureVersion != "" {
+		version.SignatureVersion = newer.SignatureVersion
+	}
+	if newer.SignatureDate != "" {
+		version.SignatureDate = newer.SignatureDate
+	}
+
+	return version
 }
 
 type fileinfo struct {
 	Name    string   `structs:"filename" json:"filename"`
 	Sha1    string   `structs:"sha1" json:"sha1"`
 	Md5     string   `structs:"md5" json:"md5"`
+	Sha256  string   `structs:"sha256" json:"sha256"`
 	Date    string   `structs:"date" json:"date"`
+	Mime    string   `structs:"mime" json:"mime"`
 	Tags    []string `structs:"tags" json:"tags"`
 	Malware malware  `structs:"malware" json:"malware"`
+
+	Parent      string `structs:"parent" json:"parent,omitempty"`             //Sha256 of the archive the file was unpacked from
+	Path        string `structs:"path" json:"path,omitempty"`                 //Path of the file inside the archive that was scanned
+	UnpackError string `structs:"unpack_error" json:"unpack_error,omitempty"` //Why unpacking the file stopped early
+	Password    string `structs:"password" json:"password,omitempty"`         //Password that opened the encrypted files in the archive
+	FromCache   bool   `structs:"from_cache" json:"from_cache,omitempty"`     //Report was reused from the result cache
+	ScannedAt   string `structs:"scanned_at" json:"scanned_at,omitempty"`     //When a report from the cache was originally scanned
 }
 
 type analyzers struct {
-	Names       []string    `structs:"name" json:"name"`
-	RawAnalysis rawAnalysis `structs:"raw-analysis" json:"raw-analysis"`
+	Names       []string                `structs:"name" json:"name"`
+	Status      map[string]PluginStatus `structs:"status" json:"status"`
+	RawAnalysis rawAnalysis             `structs:"raw-analysis" json:"raw-analysis"`
 }
 
 type malware struct {
 	Infected  bool      `structs:"infected" json:"infected"`
+	Verdict   string    `structs:"verdict" json:"verdict"`
+	Score     float64   `structs:"score" json:"score"`   //Summed weight of the av plugins that detected malware
+	Policy    string    `structs:"policy" json:"policy"` //Verdict policy the verdict was decided with
 	Results   []string  `structs:"variants" json:"variants"`
 	Analyzers analyzers `structs:"analyzers" json:"analyzers"`
+	RolledUp  bool      `structs:"rolled_up" json:"rolled_up,omitempty"` //Verdict was raised by a file unpacked from this one
+	Reason    string    `structs:"reason" json:"reason,omitempty"`       //Why the verdict could not be decided from the av results alone
 }
 
 type rawAnalysis struct {